@@ -0,0 +1,28 @@
+package behaviours
+
+import "github.com/VargasMauricio/goat/goat"
+
+const barrierTag = "goat/barrier"
+
+/*
+Barrier returns a process that waits until all the n participants (the
+components satisfying participants, its own included) have reached the barrier
+name. Each participant is identified by the attribute idAttr. A barrier name
+can be used only once: use a new name for each round.
+*/
+func Barrier(name string, idAttr string, participants goat.Predicate, n int) func(p *goat.Process) {
+    return func(p *goat.Process) {
+        sendAside(p, goat.NewTuple(barrierTag, name, "arrived", goat.Comp(idAttr)), participants)
+        arrived := map[interface{}]struct{}{}
+        for len(arrived) < n-1 {
+            msg := p.Receive(func(attr *goat.Attributes, msg goat.Tuple) bool {
+                return isTagged(msg, barrierTag, name, 4)
+            })
+            if msg.Get(2) == "arrived" {
+                // the newcomer may have missed our arrival
+                sendAside(p, goat.NewTuple(barrierTag, name, "waiting", goat.Comp(idAttr)), participants)
+            }
+            arrived[msg.Get(3)] = struct{}{}
+        }
+    }
+}
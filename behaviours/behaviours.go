@@ -0,0 +1,40 @@
+/*
+Package behaviours provides reusable processes for common coordination tasks:
+leader election, barriers, heartbeats with failure detection and token passing.
+They are parameterized by the attribute names and the predicates they use, and
+are meant to be run as processes of goat components, e.g.
+
+    c.Start(behaviours.Barrier("start", "id", goat.Equals(goat.Receiver("role"), "worker"), 4))
+
+The messages they exchange are tuples whose first element is a tag starting
+with "goat/"; processes of the same component that accept any message may steal
+them.
+*/
+package behaviours
+
+import (
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+// isTagged tells whether msg has n elements, the first being tag and the
+// second, if name is not empty, being name.
+func isTagged(msg goat.Tuple, tag string, name string, n int) bool {
+    if !msg.IsLong(n) || msg.Get(0) != tag {
+        return false
+    }
+    return name == "" || msg.Get(1) == name
+}
+
+// sendAside sends msg to pred from a new process, so that p keeps receiving
+// meanwhile: messages are not lost while p waits for its send to happen.
+func sendAside(p *goat.Process, msg goat.Tuple, pred goat.Predicate) {
+    p.Spawn(func(q *goat.Process) {
+        q.Send(msg, pred)
+    })
+}
+
+func msecUntil(deadline time.Time) int {
+    return int(time.Until(deadline) / time.Millisecond)
+}
@@ -0,0 +1,124 @@
+package behaviours
+
+import (
+    "context"
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+var peers = goat.Equals(goat.Receiver("role"), "peer")
+
+// newPeers starts a central server on port and n components connected to it,
+// with ids from 0 to n-1.
+func newPeers(port int, n int) []*goat.Component {
+    goat.RunCentralServerLoop(port)
+    time.Sleep(100 * time.Millisecond)
+    comps := make([]*goat.Component, n)
+    for i := range comps {
+        agent := goat.NewSingleServerAgent("127.0.0.1:" + strconv.Itoa(port))
+        comps[i] = goat.NewComponentWithAttributes(agent, map[string]interface{}{"id": i, "role": "peer"})
+    }
+    return comps
+}
+
+func TestElectLeader(t *testing.T) {
+    comps := newPeers(17700, 3)
+    leaders := make(chan interface{}, len(comps))
+    for _, c := range comps {
+        c.Start(func(p *goat.Process) {
+            p.Call(ElectLeader("id", "leader", peers, 500))
+            p.Set(func(attr *goat.Attributes) {
+                leaders <- attr.GetValue("leader")
+            })
+        })
+    }
+    for range comps {
+        if leader := <-leaders; leader != 2 {
+            t.Fatal("the highest id must be elected, got", leader)
+        }
+    }
+}
+
+func TestBarrier(t *testing.T) {
+    comps := newPeers(17701, 3)
+    start := time.Now()
+    released := make(chan time.Duration, len(comps))
+    for i, c := range comps {
+        delay := i * 150
+        c.Start(func(p *goat.Process) {
+            p.Sleep(delay)
+            p.Call(Barrier("b", "id", peers, len(comps)))
+            released <- time.Since(start)
+        })
+    }
+    for range comps {
+        select {
+            case after := <-released:
+                if after < 300*time.Millisecond {
+                    t.Fatal("a participant passed the barrier before the last one arrived:", after)
+                }
+            case <-time.After(3 * time.Second):
+                t.Fatal("the barrier never opened")
+        }
+    }
+}
+
+func TestFailureDetector(t *testing.T) {
+    comps := newPeers(17702, 2)
+    type change struct {
+        id interface{}
+        alive bool
+    }
+    changes := make(chan change, 10)
+    comps[1].Start(FailureDetector(300, func(id interface{}, alive bool) {
+        changes <- change{id, alive}
+    }))
+    comps[0].Start(Heartbeat("id", peers, 50))
+    for _, expected := range []change{{0, true}, {0, false}} {
+        select {
+            case got := <-changes:
+                if got != expected {
+                    t.Fatal("expected", expected, "got", got)
+                }
+            case <-time.After(3 * time.Second):
+                t.Fatal("no change detected, expected", expected)
+        }
+        if expected.alive {
+            // the heartbeats stop
+            comps[0].Drain(context.Background())
+        }
+    }
+}
+
+func TestTokenRing(t *testing.T) {
+    comps := newPeers(17703, 3)
+    lock := &sync.Mutex{}
+    holders := []int{}
+    wg := &sync.WaitGroup{}
+    wg.Add(len(comps))
+    for i, c := range comps {
+        id := i
+        rounds := 0
+        c.Start(func(p *goat.Process) {
+            p.Call(TokenRing("t", "id", len(comps), func(*goat.Process) bool {
+                lock.Lock()
+                holders = append(holders, id)
+                lock.Unlock()
+                rounds++
+                return rounds < 2
+            }))
+            wg.Done()
+        })
+    }
+    wg.Wait()
+    expected := []int{0, 1, 2, 0, 1, 2}
+    for i := range expected {
+        if holders[i] != expected[i] {
+            t.Fatal("the token must go around the ring in order, got", holders)
+        }
+    }
+}
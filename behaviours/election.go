@@ -0,0 +1,45 @@
+package behaviours
+
+import (
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+const electionTag = "goat/election"
+
+/*
+ElectLeader returns a process that elects the candidate with the highest id:
+it announces the id of its component (the int attribute idAttr) to candidates,
+collects the ids announced by the others for msec milliseconds and then sets
+the attribute leaderAttr to the highest id. All candidates must start within
+msec milliseconds of each other; then they all elect the same leader.
+*/
+func ElectLeader(idAttr string, leaderAttr string, candidates goat.Predicate, msec int) func(p *goat.Process) {
+    return func(p *goat.Process) {
+        deadline := time.Now().Add(time.Duration(msec) * time.Millisecond)
+        sendAside(p, goat.NewTuple(electionTag, goat.Comp(idAttr)), candidates)
+        highest, heard := 0, false
+        for remaining := msecUntil(deadline); remaining > 0; remaining = msecUntil(deadline) {
+            msg, ok := p.ReceiveWithin(remaining, func(attr *goat.Attributes, msg goat.Tuple) bool {
+                if !isTagged(msg, electionTag, "", 2) {
+                    return false
+                }
+                _, isInt := msg.Get(1).(int)
+                return isInt
+            })
+            if !ok {
+                continue
+            }
+            if id := msg.Get(1).(int); !heard || id > highest {
+                highest, heard = id, true
+            }
+        }
+        p.Set(func(attr *goat.Attributes) {
+            if own := attr.GetValue(idAttr).(int); !heard || own > highest {
+                highest = own
+            }
+            attr.Set(leaderAttr, highest)
+        })
+    }
+}
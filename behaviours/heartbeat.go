@@ -0,0 +1,64 @@
+package behaviours
+
+import (
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+const heartbeatTag = "goat/heartbeat"
+
+/*
+Heartbeat returns a process that sends, every periodMsec milliseconds and
+forever, a heartbeat carrying the attribute idAttr to the components satisfying
+monitors (see FailureDetector).
+*/
+func Heartbeat(idAttr string, monitors goat.Predicate, periodMsec int) func(p *goat.Process) {
+    return func(p *goat.Process) {
+        for {
+            p.Send(goat.NewTuple(heartbeatTag, goat.Comp(idAttr)), monitors)
+            p.Sleep(periodMsec)
+        }
+    }
+}
+
+/*
+FailureDetector returns a process that, forever, receives the heartbeats sent by
+Heartbeat and calls onChange(id, false) when the component id has not sent any
+for timeoutMsec milliseconds, and onChange(id, true) when a component is heard
+for the first time or again after being suspected. timeoutMsec should be a few
+heartbeat periods.
+*/
+func FailureDetector(timeoutMsec int, onChange func(id interface{}, alive bool)) func(p *goat.Process) {
+    return func(p *goat.Process) {
+        timeout := time.Duration(timeoutMsec) * time.Millisecond
+        lastSeen := map[interface{}]time.Time{}
+        suspected := map[interface{}]struct{}{}
+        checkMsec := timeoutMsec / 2
+        if checkMsec < 1 {
+            checkMsec = 1
+        }
+        for {
+            msg, ok := p.ReceiveWithin(checkMsec, func(attr *goat.Attributes, msg goat.Tuple) bool {
+                return isTagged(msg, heartbeatTag, "", 2)
+            })
+            now := time.Now()
+            if ok {
+                id := msg.Get(1)
+                _, known := lastSeen[id]
+                _, wasSuspected := suspected[id]
+                lastSeen[id] = now
+                delete(suspected, id)
+                if !known || wasSuspected {
+                    onChange(id, true)
+                }
+            }
+            for id, seen := range lastSeen {
+                if _, has := suspected[id]; !has && now.Sub(seen) > timeout {
+                    suspected[id] = struct{}{}
+                    onChange(id, false)
+                }
+            }
+        }
+    }
+}
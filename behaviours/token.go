@@ -0,0 +1,36 @@
+package behaviours
+
+import "github.com/VargasMauricio/goat/goat"
+
+const tokenTag = "goat/token"
+
+/*
+TokenRing returns a process that takes part in passing the token name around a
+ring of n components, whose int attribute idAttr ranges from 0 to n-1; the
+component with id 0 holds the token first. Whenever the process holds the
+token it runs work, then passes the token to the next component of the ring; it
+ends, after passing the token, when work returns false.
+*/
+func TokenRing(name string, idAttr string, n int, work func(p *goat.Process) bool) func(p *goat.Process) {
+    next := goat.Evaluate(func(params ...interface{}) interface{} {
+        return (params[0].(int) + 1) % n
+    }, goat.Comp(idAttr))
+    isToken := func(attr *goat.Attributes, msg goat.Tuple) bool {
+        return isTagged(msg, tokenTag, name, 3) && msg.Get(2) == attr.GetValue(idAttr)
+    }
+    return func(p *goat.Process) {
+        // the first holder starts straight away, the others wait for the token
+        p.Select(
+            goat.Case(goat.Equals(goat.Comp(idAttr), 0), goat.ThenReact(), goat.ZeroProcess),
+            goat.Case(goat.True(), goat.ThenReceive(isToken), goat.ZeroProcess),
+        )
+        for {
+            more := work(p)
+            p.Send(goat.NewTuple(tokenTag, name, next), goat.Equals(goat.Receiver(idAttr), next))
+            if !more {
+                return
+            }
+            p.Receive(isToken)
+        }
+    }
+}
@@ -0,0 +1,32 @@
+/*
+Package casestudies implements canonical case studies of attribute-based
+communication, stable marriage and graph colouring, on top of goat. The
+problem size and the infrastructure (through a function creating the agents)
+are parameters, so they serve both as regression suites, since the results are
+checked, and as scalability benchmarks.
+*/
+package casestudies
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+// drainAll stops the components of a finished run, so that repeated runs do
+// not pile up.
+func drainAll(comps []*goat.Component) {
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    wg := &sync.WaitGroup{}
+    wg.Add(len(comps))
+    for _, c := range comps {
+        go func(c *goat.Component) {
+            c.Drain(ctx)
+            wg.Done()
+        }(c)
+    }
+    wg.Wait()
+}
@@ -0,0 +1,81 @@
+package casestudies
+
+import (
+    "strconv"
+    "testing"
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+// started are the ports of the central servers started so far: benchmarks are
+// run more than once.
+var started = map[int]struct{}{}
+
+// singleServer starts a central server on port, unless already started, and
+// returns a function creating agents for it.
+func singleServer(port int) func() goat.Agent {
+    if _, has := started[port]; !has {
+        started[port] = struct{}{}
+        goat.RunCentralServerLoop(port)
+        time.Sleep(100 * time.Millisecond)
+    }
+    return func() goat.Agent {
+        return goat.NewSingleServerAgent("127.0.0.1:" + strconv.Itoa(port))
+    }
+}
+
+func TestStableMarriage(t *testing.T) {
+    newAgent := singleServer(17710)
+    for seed := int64(1); seed <= 3; seed++ {
+        res, err := StableMarriage(newAgent, 6, seed, 10*time.Second)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !res.Stable {
+            t.Fatal("the matching is not stable:", res.WifeOf)
+        }
+    }
+}
+
+func TestGraphColouring(t *testing.T) {
+    newAgent := singleServer(17711)
+    for seed := int64(1); seed <= 3; seed++ {
+        res, err := GraphColouring(newAgent, 12, 0.3, seed, 10*time.Second)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !res.Proper {
+            t.Fatal("neighbours have the same colour:", res.Colour)
+        }
+    }
+}
+
+func TestIsStable(t *testing.T) {
+    menPrefs := [][]int{{0, 1}, {0, 1}}
+    womenPrefs := [][]int{{1, 0}, {1, 0}}
+    if !IsStable(menPrefs, womenPrefs, []int{1, 0}) {
+        t.Fatal("woman 0 is married to her favourite, the matching is stable")
+    }
+    if IsStable(menPrefs, womenPrefs, []int{0, 1}) {
+        t.Fatal("man 1 and woman 0 prefer each other, the matching is not stable")
+    }
+}
+
+func BenchmarkStableMarriage(b *testing.B) {
+    newAgent := singleServer(17712)
+    for i := 0; i < b.N; i++ {
+        if _, err := StableMarriage(newAgent, 10, int64(i), time.Minute); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
+
+func BenchmarkGraphColouring(b *testing.B) {
+    newAgent := singleServer(17713)
+    for i := 0; i < b.N; i++ {
+        if _, err := GraphColouring(newAgent, 20, 0.2, int64(i), time.Minute); err != nil {
+            b.Fatal(err)
+        }
+    }
+}
@@ -0,0 +1,123 @@
+package casestudies
+
+import (
+    "fmt"
+    "math/rand"
+    "sync"
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+/*
+ColouringResult is the outcome of GraphColouring. Neighbours[v] lists the
+neighbours of the vertex v, which got the colour Colour[v]; Colours is the
+number of colours used.
+*/
+type ColouringResult struct {
+    Neighbours [][]int
+    Colour []int
+    Colours int
+    Proper bool
+    Elapsed time.Duration
+}
+
+/*
+GraphColouring colours a random graph of n vertices, where each edge is present
+with probability edgeProb, drawn from seed. Each vertex is a component created
+with an agent given by newAgent, and knows only its neighbours (the Tuple
+attribute "neighbours"): it waits for the colours of its neighbours with a
+higher id, takes the smallest colour they did not take and tells it to its
+neighbours. It fails if the graph is not coloured within timeout.
+*/
+func GraphColouring(newAgent func() goat.Agent, n int, edgeProb float64, seed int64, timeout time.Duration) (*ColouringResult, error) {
+    rnd := rand.New(rand.NewSource(seed))
+    res := &ColouringResult{Neighbours: make([][]int, n), Colour: make([]int, n)}
+    for v := 0; v < n; v++ {
+        for u := 0; u < v; u++ {
+            if rnd.Float64() < edgeProb {
+                res.Neighbours[v] = append(res.Neighbours[v], u)
+                res.Neighbours[u] = append(res.Neighbours[u], v)
+            }
+        }
+    }
+
+    wg := &sync.WaitGroup{}
+    wg.Add(n)
+    start := make(chan struct{})
+    comps := make([]*goat.Component, n)
+    for v := 0; v < n; v++ {
+        // onColoured runs later, and must see its own vertex
+        v := v
+        neighbours := make([]interface{}, len(res.Neighbours[v]))
+        for i, u := range res.Neighbours[v] {
+            neighbours[i] = u
+        }
+        comps[v] = goat.NewComponentWithAttributes(newAgent(), map[string]interface{}{"id": v, "neighbours": goat.NewTuple(neighbours...)})
+        comps[v].Start(vertex(v, res.Neighbours[v], start, func(colour int) {
+            res.Colour[v] = colour
+            wg.Done()
+        }))
+    }
+    defer drainAll(comps)
+
+    began := time.Now()
+    close(start)
+    done := make(chan struct{})
+    go func() {
+        wg.Wait()
+        close(done)
+    }()
+    select {
+        case <-done:
+        case <-time.After(timeout):
+            return nil, fmt.Errorf("graph of size %d not coloured within %v", n, timeout)
+    }
+    res.Elapsed = time.Since(began)
+    res.Proper = IsProperColouring(res.Neighbours, res.Colour)
+    for _, colour := range res.Colour {
+        if colour+1 > res.Colours {
+            res.Colours = colour + 1
+        }
+    }
+    return res, nil
+}
+
+func vertex(id int, neighbours []int, start chan struct{}, onColoured func(colour int)) func(p *goat.Process) {
+    higher := 0
+    for _, u := range neighbours {
+        if u > id {
+            higher++
+        }
+    }
+    return func(p *goat.Process) {
+        <-start
+        taken := map[int]struct{}{}
+        for heard := 0; heard < higher; heard++ {
+            msg := p.Receive(func(attr *goat.Attributes, msg goat.Tuple) bool {
+                return msg.IsLong(3) && msg.Get(0) == "colour" && msg.Get(1).(int) > id
+            })
+            taken[msg.Get(2).(int)] = struct{}{}
+        }
+        colour := 0
+        for _, has := taken[colour]; has; _, has = taken[colour] {
+            colour++
+        }
+        onColoured(colour)
+        p.Send(goat.NewTuple("colour", id, colour), goat.Belong(goat.Receiver("id"), goat.Comp("neighbours")))
+    }
+}
+
+/*
+IsProperColouring tells whether no two neighbours have the same colour.
+*/
+func IsProperColouring(neighbours [][]int, colour []int) bool {
+    for v := range neighbours {
+        for _, u := range neighbours[v] {
+            if colour[u] == colour[v] {
+                return false
+            }
+        }
+    }
+    return true
+}
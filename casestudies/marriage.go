@@ -0,0 +1,167 @@
+package casestudies
+
+import (
+    "fmt"
+    "math/rand"
+    "sync"
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+/*
+MarriageResult is the outcome of StableMarriage. MenPrefs[m] lists the women
+in the order man m prefers them, WomenPrefs[w] the men in the order woman w
+prefers them; WifeOf[m] is the woman man m is married to.
+*/
+type MarriageResult struct {
+    MenPrefs [][]int
+    WomenPrefs [][]int
+    WifeOf []int
+    Stable bool
+    Elapsed time.Duration
+}
+
+/*
+StableMarriage runs the Gale-Shapley algorithm among n men and n women with
+random preferences drawn from seed, each a component created with an agent
+given by newAgent. Men propose to women in order of preference; a woman keeps
+the best proposer so far and rejects the others, which then propose to their
+next choice. It fails if no matching is found within timeout.
+*/
+func StableMarriage(newAgent func() goat.Agent, n int, seed int64, timeout time.Duration) (*MarriageResult, error) {
+    rnd := rand.New(rand.NewSource(seed))
+    res := &MarriageResult{MenPrefs: make([][]int, n), WomenPrefs: make([][]int, n), WifeOf: make([]int, n)}
+    for i := 0; i < n; i++ {
+        res.MenPrefs[i] = rnd.Perm(n)
+        res.WomenPrefs[i] = rnd.Perm(n)
+    }
+
+    lock := &sync.Mutex{}
+    husbandOf := map[int]int{}
+    done := make(chan struct{})
+    closeDone := &sync.Once{}
+    start := make(chan struct{})
+    comps := []*goat.Component{}
+    for w := 0; w < n; w++ {
+        w := w // captured by onEngaged
+        c := goat.NewComponentWithAttributes(newAgent(), map[string]interface{}{"role": "woman", "id": w})
+        comps = append(comps, c)
+        c.Start(woman(w, res.WomenPrefs[w], start, func(husband int) {
+            lock.Lock()
+            husbandOf[w] = husband
+            if len(husbandOf) == n {
+                // nobody is left free: no proposal is pending
+                closeDone.Do(func() {
+                    close(done)
+                })
+            }
+            lock.Unlock()
+        }))
+    }
+    for m := 0; m < n; m++ {
+        c := goat.NewComponentWithAttributes(newAgent(), map[string]interface{}{"role": "man", "id": m})
+        comps = append(comps, c)
+        c.Start(man(m, res.MenPrefs[m], start))
+    }
+    defer drainAll(comps)
+
+    began := time.Now()
+    close(start)
+    select {
+        case <-done:
+        case <-time.After(timeout):
+            return nil, fmt.Errorf("stable marriage of size %d not found within %v", n, timeout)
+    }
+    res.Elapsed = time.Since(began)
+    lock.Lock()
+    for w, m := range husbandOf {
+        res.WifeOf[m] = w
+    }
+    lock.Unlock()
+    res.Stable = IsStable(res.MenPrefs, res.WomenPrefs, res.WifeOf)
+    return res, nil
+}
+
+func man(id int, prefs []int, start chan struct{}) func(p *goat.Process) {
+    return func(p *goat.Process) {
+        <-start
+        for _, w := range prefs {
+            p.Send(goat.NewTuple("propose", id), goat.And(goat.Equals(goat.Receiver("role"), "woman"), goat.Equals(goat.Receiver("id"), w)))
+            // wait to be rejected, now or after being engaged
+            p.Receive(func(attr *goat.Attributes, msg goat.Tuple) bool {
+                return msg.IsLong(1) && msg.Get(0) == "reject"
+            })
+        }
+    }
+}
+
+func woman(id int, prefs []int, start chan struct{}, onEngaged func(husband int)) func(p *goat.Process) {
+    rank := make([]int, len(prefs))
+    for i, m := range prefs {
+        rank[m] = i
+    }
+    reject := func(p *goat.Process, m int) {
+        // replies are sent aside, so that no proposal is missed meanwhile
+        p.Spawn(func(q *goat.Process) {
+            q.Send(goat.NewTuple("reject"), goat.And(goat.Equals(goat.Receiver("role"), "man"), goat.Equals(goat.Receiver("id"), m)))
+        })
+    }
+    return func(p *goat.Process) {
+        <-start
+        husband := -1
+        for {
+            msg := p.Receive(func(attr *goat.Attributes, msg goat.Tuple) bool {
+                return msg.IsLong(2) && msg.Get(0) == "propose"
+            })
+            suitor := msg.Get(1).(int)
+            switch {
+                case husband < 0:
+                    husband = suitor
+                    onEngaged(husband)
+                case rank[suitor] < rank[husband]:
+                    reject(p, husband)
+                    husband = suitor
+                    onEngaged(husband)
+                default:
+                    reject(p, suitor)
+            }
+        }
+    }
+}
+
+/*
+IsStable tells whether wifeOf is a stable matching for the given preferences,
+i.e. no man and woman prefer each other to their spouses.
+*/
+func IsStable(menPrefs [][]int, womenPrefs [][]int, wifeOf []int) bool {
+    n := len(wifeOf)
+    husbandOf := make([]int, n)
+    womanRank := make([][]int, n)
+    for w := range womenPrefs {
+        womanRank[w] = make([]int, n)
+        for i, m := range womenPrefs[w] {
+            womanRank[w][m] = i
+        }
+    }
+    married := map[int]struct{}{}
+    for m, w := range wifeOf {
+        husbandOf[w] = m
+        married[w] = struct{}{}
+    }
+    if len(married) != n {
+        return false
+    }
+    for m, prefs := range menPrefs {
+        for _, w := range prefs {
+            if w == wifeOf[m] {
+                break
+            }
+            // m prefers w to his wife: w must prefer her husband to m
+            if womanRank[w][m] < womanRank[w][husbandOf[w]] {
+                return false
+            }
+        }
+    }
+    return true
+}
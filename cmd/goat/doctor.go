@@ -0,0 +1,87 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "sort"
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+// doctorLimits are the thresholds beyond which doctor reports a problem.
+type doctorLimits struct {
+    // stale is how long a message id can stay unused.
+    stale time.Duration
+    // silent is how long a component can go without a heartbeat.
+    silent time.Duration
+    // maxLag is how many messages a component can be behind the total order.
+    maxLag int
+    // maxBuffered is how many received messages a component can keep waiting
+    // for its processes.
+    maxBuffered int
+}
+
+// doctor writes on out a report on the central server at address and returns
+// the number of problems found: message ids unused for too long (the total
+// order cannot go past them), components without heartbeat, lagging behind
+// the total order or buffering too many messages.
+func doctor(address string, limits doctorLimits, out io.Writer) (int, error) {
+    status, err := goat.QueryCentralServer(address)
+    if err != nil {
+        return 0, err
+    }
+    fmt.Fprintf(out, "%d components, next message id %d, %d messages exchanged\n",
+        len(status.Components), status.NextMessageId, status.MessagesExchanged)
+    sort.Slice(status.Components, func(i, j int) bool {
+        return status.Components[i].Id < status.Components[j].Id
+    })
+    problems := 0
+    problem := func(format string, remedy string, args ...interface{}) {
+        problems++
+        fmt.Fprintf(out, "PROBLEM: " + format + "\n", args...)
+        fmt.Fprintf(out, "  remedy: " + remedy + "\n", args...)
+    }
+    for _, comp := range status.Components {
+        name := fmt.Sprintf("component %d", comp.Id)
+        if comp.Identity != "" {
+            name += fmt.Sprintf(" (%s)", comp.Identity)
+        }
+        mids := make([]int, 0, len(comp.PendingMids))
+        for mid := range comp.PendingMids {
+            mids = append(mids, mid)
+        }
+        sort.Ints(mids)
+        for _, mid := range mids {
+            if age := comp.PendingMids[mid]; age >= limits.stale {
+                problem("message id %[2]d was assigned to %[1]s %[3]v ago and is still unused: no component can go past it.",
+                    "check that the processes of %[1]s are not stuck while sending (e.g. in a guard or an update); stopping it fills its message ids with empty messages.",
+                    name, mid, age.Round(time.Millisecond))
+            }
+        }
+        silence := comp.SinceHeartbeat
+        if silence < 0 {
+            // it never sent one
+            silence = comp.Idle
+        }
+        if silence >= limits.silent {
+            problem("%s sent no heartbeat for %v: its host, its network or its agent may be down.",
+                "check that the host of %[1]s is up and reachable; once its connection breaks the server forgets it and fills its message ids.",
+                name, silence.Round(time.Millisecond))
+        }
+        if comp.Lag >= limits.maxLag {
+            problem("%s was %d messages behind the total order at its last heartbeat: the messages for it are delivered late.",
+                "check that the guards and the accept functions of %[1]s are quick and that its host is not overloaded.",
+                name, comp.Lag)
+        }
+        if comp.Buffered >= limits.maxBuffered {
+            problem("%s keeps %d received messages in memory, waiting for its processes.",
+                "make sure some process of %[1]s is always receiving, or slow down the senders (see Component.SetSendRate).",
+                name, comp.Buffered)
+        }
+    }
+    if problems == 0 {
+        fmt.Fprintln(out, "no problems found")
+    }
+    return problems, nil
+}
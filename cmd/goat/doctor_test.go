@@ -0,0 +1,95 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+// fakeComponent registers with the central server at address speaking the
+// protocol directly, so that it can misbehave: it returns its id, a function
+// sending a line to the server and the lines received from it.
+func fakeComponent(t *testing.T, address string) (string, func(format string, args ...interface{}), *bufio.Reader) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer listener.Close()
+    out, err := net.Dial("tcp", address)
+    if err != nil {
+        t.Fatal(err)
+    }
+    send := func(format string, args ...interface{}) {
+        fmt.Fprintf(out, format + "\n", args...)
+    }
+    send("Register %d", listener.Addr().(*net.TCPAddr).Port)
+    in, err := listener.Accept()
+    if err != nil {
+        t.Fatal(err)
+    }
+    lines := bufio.NewReader(in)
+    registered := nextLine(t, lines, "Registered")
+    return registered[1], send, lines
+}
+
+func nextLine(t *testing.T, lines *bufio.Reader, cmd string) []string {
+    for {
+        line, err := lines.ReadString('\n')
+        if err != nil {
+            t.Fatal("no", cmd, "received:", err)
+        }
+        if tokens := strings.Fields(line); len(tokens) > 0 && tokens[0] == cmd {
+            return tokens
+        }
+    }
+}
+
+func TestDoctor(t *testing.T) {
+    goat.RunCentralServerLoop(17732)
+    time.Sleep(100 * time.Millisecond)
+    cid, send, lines := fakeComponent(t, "127.0.0.1:17732")
+    // a mid that is never used, and 50 messages waiting for the processes
+    send("REQ %s", cid)
+    nextLine(t, lines, "RPLY")
+    send("BEAT %s -1 50", cid)
+    time.Sleep(100 * time.Millisecond)
+
+    relaxed := doctorLimits{time.Hour, time.Hour, 1 << 30, 1 << 30}
+    tests := []struct {
+        limits doctorLimits
+        problems []string
+    }{
+        {relaxed, nil},
+        {doctorLimits{0, time.Hour, 1 << 30, 1 << 30}, []string{"is still unused"}},
+        {doctorLimits{time.Hour, 0, 1 << 30, 1 << 30}, []string{"no heartbeat"}},
+        {doctorLimits{time.Hour, time.Hour, 10, 1 << 30}, []string{"behind the total order"}},
+        {doctorLimits{time.Hour, time.Hour, 1 << 30, 10}, []string{"50 received messages"}},
+    }
+    for _, test := range tests {
+        report := &strings.Builder{}
+        n, err := doctor("127.0.0.1:17732", test.limits, report)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if n != len(test.problems) {
+            t.Errorf("%d problems instead of %d:\n%s", n, len(test.problems), report)
+        }
+        for _, problem := range test.problems {
+            if !strings.Contains(report.String(), problem) || !strings.Contains(report.String(), "remedy:") {
+                t.Errorf("%q not reported:\n%s", problem, report)
+            }
+        }
+        if len(test.problems) == 0 && !strings.Contains(report.String(), "no problems found") {
+            t.Errorf("problems reported:\n%s", report)
+        }
+    }
+
+    if _, err := doctor("127.0.0.1:17733", relaxed, &strings.Builder{}); err == nil {
+        t.Error("no server is listening")
+    }
+}
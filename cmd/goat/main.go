@@ -0,0 +1,268 @@
+/*
+Command goat connects to a goat infrastructure as a throwaway component, for
+manual testing and operations.
+
+Usage:
+
+    goat [flags] send PREDICATE [ELEM...]
+    goat [flags] watch
+    goat [flags] shell
+    goat [flags] jsonl
+    goat [flags] doctor
+
+send sends the tuple (ELEM...) to the components satisfying PREDICATE, which
+is given in the wire format produced by ClosedPredicate.String (e.g.
+'=(A|role,S|leader)' or TT). Elements that look like integers or booleans are
+sent as such, any other element is sent as a string.
+
+watch prints every message whose predicate is satisfied by the attributes of
+the throwaway component (see -attr).
+
+shell reads commands from the standard input, one per line:
+
+    send PREDICATE [ELEM...]
+    set NAME VALUE
+    get NAME
+    quit
+
+jsonl reads actions as JSON lines from the standard input and writes the
+delivered messages as JSON lines on the standard output (see RunJSONLines).
+
+doctor inspects a running central server (-infra single) and reports the
+likely problems, with suggested remediations: message ids assigned long ago
+and never used (see -stale), components without heartbeat (see -silent),
+lagging behind the total order (see -maxlag) or keeping too many received
+messages in memory (see -maxbuffer). It exits with status 1 if it finds any.
+*/
+package main
+
+import (
+    "bufio"
+    "flag"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/VargasMauricio/goat/goat"
+)
+
+type attrFlags map[string]interface{}
+
+func (af attrFlags) String() string {
+    return fmt.Sprint(map[string]interface{}(af))
+}
+
+func (af attrFlags) Set(s string) error {
+    eq := strings.Index(s, "=")
+    if eq <= 0 {
+        return fmt.Errorf("%s: expected NAME=VALUE", s)
+    }
+    af[s[:eq]] = parseValue(s[eq+1:])
+    return nil
+}
+
+func parseValue(s string) interface{} {
+    if n, err := strconv.Atoi(s); err == nil {
+        return n
+    }
+    switch s {
+        case "true":
+            return true
+        case "false":
+            return false
+    }
+    return s
+}
+
+func parseTuple(elems []string) goat.Tuple {
+    values := make([]interface{}, len(elems))
+    for i, elem := range elems {
+        values[i] = parseValue(elem)
+    }
+    return goat.NewTuple(values...)
+}
+
+func parsePredicate(s string) (goat.Predicate, error) {
+    cpred, err := goat.ToPredicate(s)
+    if err != nil {
+        return nil, err
+    }
+    pred, isPred := cpred.(goat.Predicate)
+    if !isPred {
+        return nil, fmt.Errorf("%s: invalid predicate", s)
+    }
+    return pred, nil
+}
+
+func newAgent(infra string, registration string, messageQueue string) (goat.Agent, error) {
+    switch infra {
+        case "single":
+            return goat.NewSingleServerAgent(registration), nil
+        case "cluster":
+            if messageQueue == "" {
+                return nil, fmt.Errorf("the cluster infrastructure requires -mq")
+            }
+            return goat.NewClusterAgent(messageQueue, registration), nil
+        case "ring":
+            return goat.NewRingAgent(registration), nil
+        case "tree":
+            return goat.NewTreeAgent(registration), nil
+        default:
+            return nil, fmt.Errorf("%s: unknown infrastructure", infra)
+    }
+}
+
+func usage() {
+    fmt.Fprintln(os.Stderr, "usage: goat [flags] send PREDICATE [ELEM...] | watch | shell | jsonl | doctor")
+    flag.PrintDefaults()
+    os.Exit(2)
+}
+
+func fail(err error) {
+    fmt.Fprintln(os.Stderr, "goat:", err)
+    os.Exit(1)
+}
+
+func main() {
+    attrs := attrFlags{}
+    infra := flag.String("infra", "tree", "infrastructure type: single, cluster, ring or tree")
+    registration := flag.String("addr", "127.0.0.1:17997", "address of the server (single) or of the registration node")
+    messageQueue := flag.String("mq", "", "address of the message queue (cluster only)")
+    linger := flag.Int("linger", 500, "milliseconds to wait after the last send before exiting")
+    stale := flag.Int("stale", 2000, "milliseconds after which doctor considers an unused message id stalled")
+    silent := flag.Int("silent", 5000, "milliseconds without heartbeat after which doctor considers a component unreachable")
+    maxLag := flag.Int("maxlag", 1000, "messages behind the total order beyond which doctor considers a component lagging")
+    maxBuffer := flag.Int("maxbuffer", 10000, "received messages waiting to be processed beyond which doctor considers a buffer oversized")
+    flag.Var(attrs, "attr", "attribute of the throwaway component, as NAME=VALUE (repeatable)")
+    flag.Usage = usage
+    flag.Parse()
+    if flag.NArg() == 0 {
+        usage()
+    }
+
+    goat.InitSend()
+    agent, err := newAgent(*infra, *registration, *messageQueue)
+    if err != nil {
+        fail(err)
+    }
+
+    switch flag.Arg(0) {
+        case "send":
+            if flag.NArg() < 2 {
+                usage()
+            }
+            pred, err := parsePredicate(flag.Arg(1))
+            if err != nil {
+                fail(err)
+            }
+            comp := goat.NewComponent(agent, attrs)
+            done := make(chan struct{})
+            comp.Start(func(p *goat.Process) {
+                p.Send(parseTuple(flag.Args()[2:]), pred)
+                close(done)
+            })
+            <-done
+            time.Sleep(time.Duration(*linger) * time.Millisecond)
+
+        case "watch":
+            comp := goat.NewComponent(agent, attrs)
+            comp.Start(func(p *goat.Process) {
+                for {
+                    msg := p.Receive(func(*goat.Attributes, goat.Tuple) bool {
+                        return true
+                    })
+                    fmt.Println(msg.Elems...)
+                }
+            })
+            select {}
+
+        case "shell":
+            comp := goat.NewComponent(agent, attrs)
+            done := make(chan struct{})
+            comp.Start(func(p *goat.Process) {
+                shell(p, bufio.NewScanner(os.Stdin))
+                close(done)
+            })
+            <-done
+            time.Sleep(time.Duration(*linger) * time.Millisecond)
+
+        case "jsonl":
+            comp := goat.NewComponent(agent, attrs)
+            if err := goat.RunJSONLines(comp, os.Stdin, os.Stdout); err != nil {
+                fail(err)
+            }
+            time.Sleep(time.Duration(*linger) * time.Millisecond)
+
+        case "doctor":
+            if *infra != "single" {
+                fail(fmt.Errorf("doctor supports only the single infrastructure"))
+            }
+            problems, err := doctor(*registration, doctorLimits{
+                stale: time.Duration(*stale) * time.Millisecond,
+                silent: time.Duration(*silent) * time.Millisecond,
+                maxLag: *maxLag,
+                maxBuffered: *maxBuffer,
+            }, os.Stdout)
+            if err != nil {
+                fail(err)
+            }
+            if problems > 0 {
+                os.Exit(1)
+            }
+
+        default:
+            usage()
+    }
+}
+
+func shell(p *goat.Process, in *bufio.Scanner) {
+    for in.Scan() {
+        fields := strings.Fields(in.Text())
+        if len(fields) == 0 {
+            continue
+        }
+        switch fields[0] {
+            case "send":
+                if len(fields) < 2 {
+                    fmt.Fprintln(os.Stderr, "usage: send PREDICATE [ELEM...]")
+                    continue
+                }
+                pred, err := parsePredicate(fields[1])
+                if err != nil {
+                    fmt.Fprintln(os.Stderr, err)
+                    continue
+                }
+                p.Send(parseTuple(fields[2:]), pred)
+            case "set":
+                if len(fields) != 3 {
+                    fmt.Fprintln(os.Stderr, "usage: set NAME VALUE")
+                    continue
+                }
+                p.Set(func(attr *goat.Attributes) {
+                    attr.Set(fields[1], parseValue(fields[2]))
+                })
+            case "get":
+                if len(fields) != 2 {
+                    fmt.Fprintln(os.Stderr, "usage: get NAME")
+                    continue
+                }
+                var val interface{}
+                var has bool
+                // reading within a Set keeps the read atomic with respect to the component
+                p.Set(func(attr *goat.Attributes) {
+                    val, has = attr.Get(fields[1])
+                })
+                if has {
+                    fmt.Println(val)
+                } else {
+                    fmt.Println(fields[1], "is not set")
+                }
+            case "quit":
+                return
+            default:
+                fmt.Fprintln(os.Stderr, fields[0]+": unknown command")
+        }
+    }
+}
@@ -17,3 +17,31 @@ type Agent interface{
     GetSendTime() map[int]int64
     GetReceiveTime() map[int]int64
 }
+
+/*
+SiblingAgent is implemented by the agents that can create a new agent for the
+same infrastructure, so that components can create other components at runtime
+(see Process.SpawnComponent).
+*/
+type SiblingAgent interface{
+    NewSibling() Agent
+}
+
+/*
+PriorityAgent is implemented by the agents whose infrastructure can order the
+sends of different components by priority (see
+CentralServer.SetPrioritySequencing): AskMidPriority asks a mid for a send with
+the given priority.
+*/
+type PriorityAgent interface{
+    AskMidPriority(priority int)
+}
+
+/*
+ClosingAgent is implemented by the agents that can leave the infrastructure:
+after Close the infrastructure forgets the component, and the agent neither
+sends nor receives anything anymore.
+*/
+type ClosingAgent interface{
+    Close()
+}
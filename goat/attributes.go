@@ -9,6 +9,9 @@ type Attributes struct {
 	actual map[string]interface{}
 	changes map[string]interface{}
 	onUpdate *signaling
+	// onCommit, if set, is called with the committed values after every
+	// commit that changes them
+	onCommit func(actual map[string]interface{})
 }
 
 func NewAttributes() *Attributes{
@@ -91,23 +94,25 @@ are permanently saved. Returns True whether there was any change to the attribut
 */
 func (attr *Attributes) commit() bool{
 	if attr.actual == nil{
-		attr.actual = attr.changes
-		return attr.changes != nil && len(attr.changes) > 0
-	} else {
-		anyChange := len(attr.changes)>0
-		_ = anyChange
-		for k, v := range attr.changes{
-			attr.actual[k] = v
-		}
-		attr.changes = nil
-		if anyChange{
-		    dprintln("attrchange")
-		    attr.onUpdate.Signal()
-		    return true//anyChange
-	    } else {
-	        return false
+		// the staged changes must not become the committed map itself,
+		// otherwise later changes would be visible before their commit
+		attr.actual = map[string]interface{}{}
+	}
+	anyChange := len(attr.changes)>0
+	for k, v := range attr.changes{
+		attr.actual[k] = v
+	}
+	attr.changes = nil
+	if anyChange{
+	    dprintln("attrchange")
+	    if attr.onCommit != nil {
+	        attr.onCommit(attr.actual)
 	    }
-	}  
+	    attr.onUpdate.Signal()
+	    return true//anyChange
+    } else {
+        return false
+    }
 }
 
 /*
@@ -0,0 +1,154 @@
+package goat
+
+import (
+    "encoding/gob"
+    "fmt"
+    "plugin"
+    "sort"
+    "sync"
+)
+
+func init() {
+    gob.Register(BehaviourRef{})
+}
+
+/*
+BehaviourRegistry holds a set of named process behaviours. Every component has
+its own registry (see Component.Behaviours), that can be extended at runtime
+by registering new functions or by loading Go plugins.
+*/
+type BehaviourRegistry struct {
+    lock *sync.Mutex
+    behaviours map[string]func(*Process)
+}
+
+func NewBehaviourRegistry() *BehaviourRegistry {
+    return &BehaviourRegistry{
+        lock: &sync.Mutex{},
+        behaviours: map[string]func(*Process){},
+    }
+}
+
+/*
+Register associates procFnc to name, replacing any previous behaviour with the
+same name.
+*/
+func (br *BehaviourRegistry) Register(name string, procFnc func(p *Process)) {
+    br.lock.Lock()
+    br.behaviours[name] = procFnc
+    br.lock.Unlock()
+}
+
+/*
+Get returns the behaviour registered as name, and whether it exists.
+*/
+func (br *BehaviourRegistry) Get(name string) (func(*Process), bool) {
+    br.lock.Lock()
+    procFnc, has := br.behaviours[name]
+    br.lock.Unlock()
+    return procFnc, has
+}
+
+/*
+Names returns the sorted names of the registered behaviours.
+*/
+func (br *BehaviourRegistry) Names() []string {
+    br.lock.Lock()
+    names := make([]string, 0, len(br.behaviours))
+    for name := range br.behaviours {
+        names = append(names, name)
+    }
+    br.lock.Unlock()
+    sort.Strings(names)
+    return names
+}
+
+/*
+LoadPlugin opens the Go plugin at path (built with -buildmode=plugin) and
+registers the behaviours it exports. The plugin must define the variable
+
+    var Behaviours = map[string]func(*goat.Process){...}
+
+LoadPlugin returns the names of the behaviours that have been registered.
+*/
+func (br *BehaviourRegistry) LoadPlugin(path string) ([]string, error) {
+    plg, err := plugin.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    sym, err := plg.Lookup("Behaviours")
+    if err != nil {
+        return nil, err
+    }
+    return br.registerSymbol(path, sym)
+}
+
+// registerSymbol registers the behaviours of sym, the Behaviours variable of
+// the plugin at path.
+func (br *BehaviourRegistry) registerSymbol(path string, sym plugin.Symbol) ([]string, error) {
+    behaviours, isMap := sym.(*map[string]func(*Process))
+    if !isMap {
+        return nil, fmt.Errorf("%s: Behaviours is %T, not map[string]func(*goat.Process)", path, sym)
+    }
+    names := make([]string, 0, len(*behaviours))
+    for name, procFnc := range *behaviours {
+        br.Register(name, procFnc)
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+/*
+SpawnNamed creates a new process on the same component that behaves like the
+behaviour registered as name in the component's registry.
+*/
+func (p *Process) SpawnNamed(name string) error {
+    procFnc, has := p.Comp.behaviours.Get(name)
+    if !has {
+        return fmt.Errorf("%s: %w", name, ErrUnknownBehaviour)
+    }
+    p.Spawn(procFnc)
+    return nil
+}
+
+/*
+BehaviourRef names a behaviour inside a message, so that the receiver can run
+it (see RunReceived). Only the name travels: the receiver runs its own
+behaviour registered with that name.
+*/
+type BehaviourRef struct {
+    Name string
+}
+
+func Behaviour(name string) BehaviourRef {
+    return BehaviourRef{name}
+}
+
+/*
+RunReceived blocks p until it receives a message, satisfying accept, whose
+first element is a BehaviourRef to a behaviour registered in the component. Then
+it spawns that behaviour and returns the message, that can carry its
+parameters. Messages referring to unknown behaviours are rejected, and reported
+on the Errors of the component as ErrUnknownBehaviour.
+*/
+func (p *Process) RunReceived(accept func(attr *Attributes, msg Tuple) bool) Tuple {
+    var procFnc func(*Process)
+    msg := p.Receive(func(attr *Attributes, msg Tuple) bool {
+        if msg.Length() == 0 {
+            return false
+        }
+        ref, isRef := msg.Get(0).(BehaviourRef)
+        if !isRef {
+            return false
+        }
+        var has bool
+        if procFnc, has = p.Comp.behaviours.Get(ref.Name); !has {
+            p.Comp.reportError(fmt.Errorf("received %s: %w", ref.Name, ErrUnknownBehaviour))
+            return false
+        }
+        return accept(attr, msg)
+    })
+    p.Spawn(procFnc)
+    return msg
+}
@@ -0,0 +1,119 @@
+package goat
+
+import (
+    "errors"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestBehaviourRegistry(t *testing.T) {
+    br := NewBehaviourRegistry()
+    if _, has := br.Get("ping"); has {
+        t.Fatal("an empty registry has no behaviours")
+    }
+    calls := []string{}
+    br.Register("ping", func(*Process) { calls = append(calls, "ping") })
+    br.Register("echo", func(*Process) { calls = append(calls, "echo") })
+    br.Register("ping", func(*Process) { calls = append(calls, "ping2") })
+    if names := br.Names(); !reflect.DeepEqual(names, []string{"echo", "ping"}) {
+        t.Error(names)
+    }
+    procFnc, has := br.Get("ping")
+    if !has {
+        t.Fatal("ping not found")
+    }
+    procFnc(nil)
+    if !reflect.DeepEqual(calls, []string{"ping2"}) {
+        t.Error("Register must replace the behaviour with the same name", calls)
+    }
+    if _, has := br.Get("pong"); has {
+        t.Error("pong was never registered")
+    }
+}
+
+func TestLoadPluginErrors(t *testing.T) {
+    br := NewBehaviourRegistry()
+    if _, err := br.LoadPlugin("no/such/plugin.so"); err == nil {
+        t.Error("a missing plugin must give an error")
+    }
+    wrong := map[string]func(){"ping": func() {}}
+    names, err := br.registerSymbol("wrong.so", &wrong)
+    if err == nil || names != nil || !strings.Contains(err.Error(), "wrong.so: Behaviours is *map[string]func()") {
+        t.Error("a Behaviours of another type must give an error", names, err)
+    }
+    if len(br.Names()) != 0 {
+        t.Error("nothing must be registered from an invalid plugin", br.Names())
+    }
+    right := map[string]func(*Process){"b": func(*Process) {}, "a": func(*Process) {}}
+    if names, err := br.registerSymbol("right.so", &right); err != nil || !reflect.DeepEqual(names, []string{"a", "b"}) {
+        t.Error(names, err)
+    }
+    if !reflect.DeepEqual(br.Names(), []string{"a", "b"}) {
+        t.Error(br.Names())
+    }
+}
+
+func TestSpawnNamed(t *testing.T) {
+    c := NewComponent(NewSingleServerAgent(testServer(17738)), nil)
+    spawned := make(chan string, 1)
+    c.Behaviours().Register("worker", func(p *Process) {
+        spawned <- "worker"
+    })
+    errs := make(chan error, 2)
+    c.Start(func(p *Process) {
+        errs <- p.SpawnNamed("missing")
+        errs <- p.SpawnNamed("worker")
+    })
+    if err := <-errs; !errors.Is(err, ErrUnknownBehaviour) || !strings.HasPrefix(err.Error(), "missing: ") {
+        t.Error("spawning an unregistered behaviour must give an error", err)
+    }
+    if err := <-errs; err != nil {
+        t.Error(err)
+    }
+    select {
+        case <-spawned:
+        case <-time.After(5 * time.Second):
+            t.Fatal("the registered behaviour was not spawned")
+    }
+}
+
+func TestRunReceived(t *testing.T) {
+    server := testServer(17746)
+    r := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "r"})
+    spawned := make(chan interface{}, 2)
+    r.Behaviours().Register("greet", func(p *Process) {
+        spawned <- "greet"
+    })
+    results := make(chan interface{}, 2)
+    r.Start(func(p *Process) {
+        results <- p.RunReceived(func(attr *Attributes, msg Tuple) bool {
+            return msg.IsLong(2)
+        })
+    })
+    toR := Equals(Receiver("name"), "r")
+    NewComponent(NewSingleServerAgent(server), nil).Start(func(p *Process) {
+        p.Send(NewTuple(Behaviour("steal"), "bob"), toR)
+        p.Send(NewTuple("greet", "bob"), toR)
+        p.Send(NewTuple(Behaviour("greet"), "bob"), toR)
+    })
+    msg := within(t, results, "the known behaviour was not received").(Tuple)
+    if msg.Get(0) != Behaviour("greet") || msg.Get(1) != "bob" {
+        t.Error("RunReceived must return the message of the known behaviour, not", msg)
+    }
+    within(t, spawned, "the known behaviour was not spawned")
+    select {
+        case err := <-r.Errors():
+            if !errors.Is(err, ErrUnknownBehaviour) || !strings.Contains(err.Error(), "steal") {
+                t.Error(err)
+            }
+        case <-time.After(5 * time.Second):
+            t.Error("the unknown behaviour was not reported")
+    }
+    select {
+        case what := <-spawned:
+            t.Error(what, "spawned twice")
+        default:
+    }
+}
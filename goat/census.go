@@ -0,0 +1,190 @@
+package goat
+
+import (
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+)
+
+/*
+CensusAgent is implemented by the agents whose infrastructure keeps a census of
+the attributes published by the components (currently only SingleServerAgent),
+so that aggregates over the population can be computed without a census
+protocol among the components.
+*/
+type CensusAgent interface {
+    PublishAttributes(attrs map[string]interface{})
+    Count(pred ClosedPredicate) (int, error)
+}
+
+var errNoCensus = errors.New("the agent does not support a census")
+
+/*
+Publish sends the current value of the attributes names to the census of the
+infrastructure. Only attributes of type string, int, bool or Tuple can be
+published; the others are ignored. Each call replaces the attributes published
+before, hence it must be repeated whenever the published values change (see
+AutoPublish). The census is not synchronized with the order of the messages.
+*/
+func (c *Component) Publish(names ...string) error {
+    ca, isCensus := c.agent.(CensusAgent)
+    if !isCensus {
+        return errNoCensus
+    }
+    values := map[string]interface{}{}
+    done := make(chan struct{})
+    NewProcess(c).Run(func(p *Process) {
+        p.Set(func(attr *Attributes) {
+            for _, name := range names {
+                if val, has := attr.Get(name); has {
+                    values[name] = val
+                }
+            }
+        })
+        close(done)
+    })
+    <-done
+    ca.PublishAttributes(values)
+    return nil
+}
+
+/*
+AutoPublish publishes the attributes names (see Publish) now and again every
+time a change to the attributes of c is committed, so that the census follows
+them. It must be called before the component is started.
+*/
+func (c *Component) AutoPublish(names ...string) error {
+    ca, isCensus := c.agent.(CensusAgent)
+    if !isCensus {
+        return errNoCensus
+    }
+    // only the latest values are worth publishing
+    chnValues := make(chan map[string]interface{}, 1)
+    pick := func(actual map[string]interface{}) {
+        values := map[string]interface{}{}
+        for _, name := range names {
+            if val, has := actual[name]; has {
+                values[name] = val
+            }
+        }
+        for {
+            select {
+                case chnValues <- values:
+                    return
+                default:
+                    select {
+                        case <-chnValues:
+                        default:
+                    }
+            }
+        }
+    }
+    pick(c.attributes.actual)
+    c.attributes.onCommit = pick
+    go func() {
+        defer recoverTo("the census publisher", c.reportError)
+        last := ""
+        for {
+            select {
+                case values := <-chnValues:
+                    // fmt prints maps sorted by key
+                    if enc := fmt.Sprint(values); enc != last {
+                        last = enc
+                        ca.PublishAttributes(values)
+                    }
+                case <-c.chnStopped:
+                    return
+            }
+        }
+    }()
+    return nil
+}
+
+/*
+Count returns how many components (c included) have published attributes that
+satisfy pred. Receiver attributes in pred refer to the published attributes.
+It fails with ErrQueryTimeout if the infrastructure does not answer in time.
+*/
+func (c *Component) Count(pred ClosedPredicate) (int, error) {
+    ca, isCensus := c.agent.(CensusAgent)
+    if !isCensus {
+        return 0, errNoCensus
+    }
+    return ca.Count(pred)
+}
+
+// censusRefresh is how often the counts used by CountOf are refreshed.
+const censusRefresh = 200 * time.Millisecond
+
+// countWatch is the last known result of a count used by CountOf.
+type countWatch struct {
+    lock *sync.Mutex
+    n int
+}
+
+func (cw *countWatch) get() int {
+    cw.lock.Lock()
+    defer cw.lock.Unlock()
+    return cw.n
+}
+
+// set stores n and returns true if it differs from the previous count.
+func (cw *countWatch) set(n int) bool {
+    cw.lock.Lock()
+    defer cw.lock.Unlock()
+    changed := cw.n != n
+    cw.n = n
+    return changed
+}
+
+/*
+CountOf evaluates to the number of components counted by c.Count(pred), so
+that aggregates can be used in awareness predicates, e.g.
+LessThan(CountOf(c, leaders), 3). Evaluating it does not query the
+infrastructure: the count is refreshed in the background (every 200 ms) until
+c stops, and the guards of c are evaluated again when it changes. If the
+count cannot be computed (e.g. the agent of c does not support a census) it
+evaluates to the last known count, initially 0, and the failure is reported on
+Errors.
+*/
+func CountOf(c *Component, pred ClosedPredicate) evalattr {
+    cw := c.watchCount(pred)
+    return Evaluate(func(...interface{}) interface{} {
+        return cw.get()
+    })
+}
+
+// watchCount returns the count of pred kept up to date for CountOf, starting
+// to watch it if needed.
+func (c *Component) watchCount(pred ClosedPredicate) *countWatch {
+    key := pred.String()
+    c.censusLock.Lock()
+    defer c.censusLock.Unlock()
+    if cw, has := c.countWatches[key]; has {
+        return cw
+    }
+    cw := &countWatch{lock: &sync.Mutex{}}
+    c.countWatches[key] = cw
+    if _, isCensus := c.agent.(CensusAgent); !isCensus {
+        c.reportError(errNoCensus)
+        return cw
+    }
+    go func() {
+        defer recoverTo("the census watcher", c.reportError)
+        for {
+            n, err := c.Count(pred)
+            if err != nil {
+                c.reportError(err)
+            } else if cw.set(n) {
+                c.attributes.onUpdate.Signal()
+            }
+            select {
+                case <-time.After(censusRefresh):
+                case <-c.chnStopped:
+                    return
+            }
+        }
+    }()
+    return cw
+}
@@ -0,0 +1,51 @@
+package goat
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestCountOf(t *testing.T) {
+    server := testServer(17727)
+    a := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"role": "follower"})
+    b := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"role": "follower"})
+    sink := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"role": "sink"})
+    for _, c := range []*Component{a, b} {
+        if err := c.AutoPublish("role"); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got := make(chan Tuple, 1)
+    sink.Start(func(p *Process) {
+        got <- p.Receive(func(*Attributes, Tuple) bool { return true })
+    })
+    leaders := Equals(Receiver("role"), "leader").CloseUnder(NewAttributes())
+    a.Start(func(p *Process) {
+        p.WaitSend(GreaterThanOrEqual(CountOf(a, leaders), 1), NewTuple("led"), Equals(Receiver("role"), "sink"))
+    })
+    time.Sleep(3 * censusRefresh)
+    select {
+        case <-got:
+            t.Fatal("the guard held without leaders")
+        default:
+    }
+    b.Start(func(p *Process) {
+        p.Set(func(attr *Attributes) {
+            attr.Set("role", "leader")
+        })
+    })
+    select {
+        case <-got:
+        case <-time.After(5 * time.Second):
+            t.Fatal("the guard was not evaluated again when the count changed")
+    }
+    if n, err := a.Count(leaders); err != nil || n != 1 {
+        t.Error(n, err)
+    }
+
+    b.GetAgent().(*SingleServerAgent).Close()
+    if _, err := b.Count(leaders); !errors.Is(err, ErrDisconnected) {
+        t.Error("a closed agent counted:", err)
+    }
+}
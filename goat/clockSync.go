@@ -0,0 +1,93 @@
+package goat
+
+import (
+    "errors"
+    "sync"
+    "time"
+)
+
+/*
+ClockAgent is implemented by the agents that can read the clock of their
+infrastructure (currently only SingleServerAgent), which components use as a
+common reference (see Component.SyncClock).
+*/
+type ClockAgent interface {
+    // ServerTime fails with ErrDisconnected or ErrQueryTimeout if the
+    // infrastructure does not answer.
+    ServerTime() (time.Time, error)
+}
+
+var errNoClock = errors.New("the agent cannot read the clock of the infrastructure")
+
+type syncedClock struct {
+    lock *sync.Mutex
+    offset time.Duration
+    bound time.Duration
+    synced bool
+}
+
+func newSyncedClock() *syncedClock {
+    return &syncedClock{lock: &sync.Mutex{}}
+}
+
+/*
+SyncClock estimates the offset of the local clock from the clock of the
+infrastructure, by reading the latter samples times and keeping the reading
+with the shortest round trip. It returns the error bound of the estimate: the
+timestamps given by TimestampNow differ from the clock of the infrastructure by
+at most that much (plus the drift accumulated since the synchronization, hence
+SyncClock should be called periodically). Timestamps of components synchronized
+with bounds b1 and b2 are comparable within b1+b2. If a reading fails SyncClock
+returns its error, and the previous estimate is kept.
+*/
+func (c *Component) SyncClock(samples int) (time.Duration, error) {
+    ca, hasClock := c.agent.(ClockAgent)
+    if !hasClock {
+        return 0, errNoClock
+    }
+    var bestOffset, bestBound time.Duration
+    for i := 0; i < samples; i++ {
+        start := timeNow()
+        serverTime, err := ca.ServerTime()
+        if err != nil {
+            return 0, err
+        }
+        end := timeNow()
+        bound := end.Sub(start) / 2
+        if i == 0 || bound < bestBound {
+            bestBound = bound
+            // the server read its clock about halfway through the round trip
+            bestOffset = serverTime.Sub(start.Add(bound))
+        }
+    }
+    if samples > 0 {
+        c.clock.lock.Lock()
+        c.clock.offset = bestOffset
+        c.clock.bound = bestBound
+        c.clock.synced = true
+        c.clock.lock.Unlock()
+    }
+    return bestBound, nil
+}
+
+/*
+TimestampNow returns the current time according to the clock of the
+infrastructure, as estimated by the last SyncClock; before any synchronization
+it is the local time.
+*/
+func (c *Component) TimestampNow() time.Time {
+    c.clock.lock.Lock()
+    offset := c.clock.offset
+    c.clock.lock.Unlock()
+    return timeNow().Add(offset)
+}
+
+/*
+ClockBound returns the error bound of the last SyncClock, and false if the
+clock has never been synchronized.
+*/
+func (c *Component) ClockBound() (time.Duration, bool) {
+    c.clock.lock.Lock()
+    defer c.clock.lock.Unlock()
+    return c.clock.bound, c.clock.synced
+}
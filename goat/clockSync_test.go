@@ -0,0 +1,31 @@
+package goat
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestSyncClock(t *testing.T) {
+    server := testServer(17734)
+    c := NewComponent(NewSingleServerAgent(server), nil)
+    if _, synced := c.ClockBound(); synced {
+        t.Fatal("not synchronized yet")
+    }
+    bound, err := c.SyncClock(3)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // the server runs on the same host
+    if bound > time.Second || c.TimestampNow().Sub(time.Now()) > bound + time.Second {
+        t.Error("wrong estimate:", bound, c.TimestampNow())
+    }
+
+    c.GetAgent().(*SingleServerAgent).Close()
+    if _, err := c.SyncClock(3); !errors.Is(err, ErrDisconnected) {
+        t.Error("a closed agent read the clock:", err)
+    }
+    if b, synced := c.ClockBound(); !synced || b != bound {
+        t.Error("a failed synchronization must keep the previous estimate")
+    }
+}
@@ -2,7 +2,6 @@ package goat
 
 import(
     "net"
-    "time"
     "sync"
     //"fmt"
 )
@@ -24,6 +23,7 @@ type ClusterAgent struct{
     lockST *sync.Mutex
     receiveTime map[int]int64
     sendTime map[int]int64
+    onError func(error)
 }
 
 func NewClusterAgent(messageQueueAddress string, registrationAddress string) *ClusterAgent{
@@ -45,6 +45,15 @@ func NewClusterAgent(messageQueueAddress string, registrationAddress string) *Cl
     return &ca
 }
 
+func (ca *ClusterAgent) SetErrorHandler(handler func(error)) {
+    ca.onError = handler
+}
+
+// NewSibling returns a new agent for the same cluster.
+func (ca *ClusterAgent) NewSibling() Agent {
+    return NewClusterAgent(ca.messageQueueAddress, ca.registrationAddress)
+}
+
 func (ca *ClusterAgent) Start(){
     ca.listener, ca.listeningPort = listenToRandomPort()
     
@@ -52,9 +61,15 @@ func (ca *ClusterAgent) Start(){
     
     //Register
     sendTo(ca.registrationAddress, "Register", itoa(ca.listeningPort))
-    go ca.doIncomingProcess(chnRegistered)
+    go func(){
+        defer recoverTo("the agent", ca.onError)
+        ca.doIncomingProcess(chnRegistered)
+    }()
     <- chnRegistered
-    go ca.doOutcomingProcess()
+    go func(){
+        defer recoverTo("the agent", ca.onError)
+        ca.doOutcomingProcess()
+    }()
 }
 
 func (ca *ClusterAgent) GetComponentId() int{
@@ -79,7 +94,7 @@ func (ca *ClusterAgent) doIncomingProcess(chnRegistered chan<- struct{}) {
                 ca.chnMids.In <- mid
                 
             case "DATA":
-                pred, _ := ToPredicate(params[2])
+                pred := decodePredicate(params[2], ca.onError)
                 mid := atoi(params[0])
                 if ca.firstMessageId >= 0 && mid >= ca.firstMessageId {
                     //cid := atoi(params[1])
@@ -89,7 +104,7 @@ func (ca *ClusterAgent) doIncomingProcess(chnRegistered chan<- struct{}) {
                         Pred: pred,
                         Message: decodeTuple(params[3]),
                     }
-                    rtime := time.Now().UnixNano()
+                    rtime := timeNow().UnixNano()
                     ca.lockST.Lock()
                     if mid > ca.maxMid{
                         ca.maxMid = mid
@@ -108,7 +123,7 @@ func (ca *ClusterAgent) doOutcomingProcess() {
     for {
         select {
             case msgToSend := <- ca.chnMessagesOut:
-                stime := time.Now().UnixNano()
+                stime := timeNow().UnixNano()
                 sendTo(ca.messageQueueAddress, "add", "DATA", itoa(msgToSend.Id), itoa(ca.componentId), msgToSend.Pred.String(), msgToSend.Message.encode() )
                 ca.lockST.Lock()
                 if msgToSend.Id >= ca.maxMid {
@@ -1,5 +1,13 @@
 package goat
 
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "time"
+)
+
 type Component struct {
     agent Agent
     midHandler *midHandler
@@ -8,6 +16,18 @@ type Component struct {
     inProcess *inProcess
     chnSubscribe chan []*Process
     chnUnsubscribe chan *Process
+    behaviours *BehaviourRegistry
+    scheduler *StochasticScheduler
+    clock *syncedClock
+    chnErrors chan error
+    chnStopped chan struct{}
+    stopOnce *sync.Once
+    maxEnvelopeSize int
+    sendTimeout int
+    sendLimiter *tokenBucket
+    sendRatePolicy SendRatePolicy
+    censusLock *sync.Mutex
+    countWatches map[string]*countWatch
 }
 
 /*
@@ -18,9 +38,19 @@ func NewComponentWithAttributes(agent Agent, attrInit map[string]interface{}) *C
     chnSubscribe := make(chan []*Process)
     chnUnsubscribe := make(chan *Process)
     attributes := NewAttributes()
-    inProcess := newInProcess(agent.GetRplyChan(), agent.GetDataChan())
-    midHandler := NewMidHandler(inProcess.chnFreshMid, agent, attributes, inProcess.chnNext)
-    messageDispatcher := newMessageDispatcher(inProcess.chnMessage, chnSubscribe, chnUnsubscribe, inProcess.chnNext, attributes)
+    chnErrors := make(chan error, errorsBuffer)
+    chnStopped := make(chan struct{})
+    stopOnce := &sync.Once{}
+    // a panic in an internal goroutine stops the component
+    onPanic := func(err error) {
+        reportErrorTo(chnErrors, err)
+        stopOnce.Do(func() {
+            close(chnStopped)
+        })
+    }
+    inProcess := newInProcess(agent.GetRplyChan(), agent.GetDataChan(), onPanic)
+    midHandler := NewMidHandler(inProcess.chnFreshMid, agent, attributes, inProcess.chnNext, onPanic)
+    messageDispatcher := newMessageDispatcher(inProcess.chnMessage, chnSubscribe, chnUnsubscribe, inProcess.chnNext, attributes, onPanic)
     
 	c := Component{
 		attributes: attributes,
@@ -30,12 +60,23 @@ func NewComponentWithAttributes(agent Agent, attrInit map[string]interface{}) *C
         inProcess: inProcess,
         chnSubscribe: chnSubscribe,
         chnUnsubscribe: chnUnsubscribe,
+        behaviours: NewBehaviourRegistry(),
+        scheduler: NewStochasticScheduler(timeNow().UnixNano(), time.Second),
+        clock: newSyncedClock(),
+        chnErrors: chnErrors,
+        chnStopped: chnStopped,
+        stopOnce: stopOnce,
+        censusLock: &sync.Mutex{},
+        countWatches: map[string]*countWatch{},
 	}
 	if attrInit != nil {
 		c.attributes.init(attrInit)
 	}
 	//c.ncomm = netCommunicationInitAndRun(server)
 	//c.agent = NewSingleServerAgent(server)
+	if era, canReport := c.agent.(ErrorReportingAgent); canReport {
+	    era.SetErrorHandler(c.reportError)
+	}
 	c.agent.Start()
 	dprintln(c.agent.GetComponentId(),"started")
 	//c.nid = c.ncomm.firstMessageId
@@ -61,6 +102,163 @@ func (c *Component) OnMid(mid int) chan struct{} {
     return chnEvt
 }
 
+// sendOnce sends msg to pred from a short-lived process and returns once the
+// message has been sent, so that code running outside processes can send. It
+// fails with ErrSendTimeout if a send timeout is set and expires, and with
+// ErrRateLimited if the send rate is exceeded and SendRateError is set.
+func (c *Component) sendOnce(msg Tuple, pred Predicate) error {
+    var err error
+    done := make(chan struct{})
+    q := NewProcess(c)
+    if c.sendLimiter != nil && c.sendRatePolicy == SendRateError {
+        if !c.sendLimiter.take() {
+            return ErrRateLimited
+        }
+        q.tokenTaken = true
+    }
+    q.Run(func(p *Process) {
+        if c.sendTimeout > 0 {
+            if !p.SendWithin(c.sendTimeout, msg, pred) {
+                err = fmt.Errorf("%w after %d ms", ErrSendTimeout, c.sendTimeout)
+            }
+        } else {
+            p.Send(msg, pred)
+        }
+        close(done)
+    })
+    <-done
+    return err
+}
+
+/*
+SetSendTimeout sets how long the sends performed on behalf of code running
+outside processes (e.g. by RunJSONLines, ControlServer, MQTTBridge and
+KafkaConnector) wait before failing with ErrSendTimeout. 0, the default, means
+forever. It must be called before the component is started.
+*/
+func (c *Component) SetSendTimeout(msec int) {
+    c.sendTimeout = msec
+}
+
+/*
+SetMaxEnvelopeSize sets the maximum size, in bytes, of the encoded messages
+sent by c: larger messages are dropped and ErrEnvelopeTooLarge is reported on
+Errors. 0, the default, means no limit. It must be called before the component
+is started.
+*/
+func (c *Component) SetMaxEnvelopeSize(size int) {
+    c.maxEnvelopeSize = size
+}
+
+/*
+Behaviours returns the registry of named behaviours of the component.
+*/
+func (c *Component) Behaviours() *BehaviourRegistry {
+    return c.behaviours
+}
+
+/*
+Scheduler returns the scheduler used by the rated actions and probabilistic
+choices of the component. By default rates are expressed per second.
+*/
+func (c *Component) Scheduler() *StochasticScheduler {
+    return c.scheduler
+}
+
+/*
+SetScheduler replaces the scheduler of the component, e.g. with a seeded one.
+It must be called before the component is started.
+*/
+func (c *Component) SetScheduler(s *StochasticScheduler) {
+    c.scheduler = s
+}
+
+/*
+NewSibling creates a new component on the same infrastructure of c, whose
+environment is initialized according to attrInit. It fails if the agent of c
+does not implement SiblingAgent.
+*/
+func (c *Component) NewSibling(attrInit map[string]interface{}) (*Component, error) {
+    sa, canClone := c.agent.(SiblingAgent)
+    if !canClone {
+        return nil, errors.New("the agent cannot create agents for the same infrastructure")
+    }
+    return NewComponentWithAttributes(sa.NewSibling(), attrInit), nil
+}
+
+/*
+Drain stops c cleanly, e.g. before restarting the host it runs on. From then on
+the processes of c are not offered message ids anymore, so they cannot start
+new sends (SendWithin and the like fail when their timeout expires), and the
+message ids already asked to the infrastructure are filled with empty messages.
+The messages sequenced so far keep being delivered until the frontier (the
+highest message id known to c) is reached; then c stops, Stopped is closed,
+and the agent is closed if it implements ClosingAgent, so that the
+infrastructure forgets c. If ctx is done first Drain returns its error and c
+stays in drain mode.
+*/
+func (c *Component) Drain(ctx context.Context) error {
+    chnLastMid := make(chan int, 1)
+    select {
+        case c.midHandler.chnDrain <- chnLastMid:
+        case <-ctx.Done():
+            return ctx.Err()
+    }
+    var frontier int
+    select {
+        case frontier = <-chnLastMid:
+        case <-ctx.Done():
+            return ctx.Err()
+    }
+    if maxMid := c.agent.GetMaxMid(); maxMid > frontier {
+        frontier = maxMid
+    }
+    chnDrained := make(chan struct{})
+    select {
+        case c.inProcess.chnDrain <- drainRequest{frontier, chnDrained}:
+        case <-ctx.Done():
+            return ctx.Err()
+    }
+    select {
+        case <-chnDrained:
+        case <-ctx.Done():
+            return ctx.Err()
+    }
+    c.stopOnce.Do(func() {
+        close(c.chnStopped)
+    })
+    if ca, canClose := c.agent.(ClosingAgent); canClose {
+        ca.Close()
+    }
+    return nil
+}
+
+/*
+SetDispatchPolicy sets the order in which the processes of c are offered the
+incoming messages (DispatchRoundRobin by default).
+*/
+func (c *Component) SetDispatchPolicy(policy DispatchPolicy) {
+    c.messageDispatcher.SetPolicy(policy)
+}
+
+/*
+SetDeadLetterHandler sets a function called with each message that no process
+of c accepted, e.g. for diagnostics or fallback handling; nil removes it. The
+handler is called in the order of the messages, before the next one is
+delivered, hence it should return quickly.
+*/
+func (c *Component) SetDeadLetterHandler(handler func(DeadLetter)) {
+    if handler == nil {
+        c.messageDispatcher.SetDeadLetterHandler(nil)
+        return
+    }
+    c.messageDispatcher.SetDeadLetterHandler(func(msg Message) {
+        catchPanic("the dead-letter handler", c.reportError, func() {
+            handler(DeadLetter{msg.Id, msg.Message, msg.Pred, c.attributes.Satisfy(msg.Pred)})
+        })
+    })
+}
+
 func (c *Component) GetAgent() Agent {
     return c.agent
 }
@@ -0,0 +1,259 @@
+package goat
+
+import (
+    "strconv"
+    "strings"
+    "sync"
+)
+
+/*
+ControlServer exposes a component to external orchestrators. It speaks the
+same line protocol of the infrastructure; each request gets one reply:
+
+    GET name              -> VALUE typedValue | UNSET
+    BEHAVIOURS            -> BEHAVIOURS name...
+    SPAWN name            -> OK pid | ERR reason
+    KILL pid              -> OK | ERR reason
+    SEND pred typedValue* -> OK | ERR reason
+
+where pred is the encoding produced by ClosedPredicate.String and a typed
+value is one of "S|text", "I|42", "B|true", "T|encodedTuple". As for the
+infrastructure, every token is escaped with escape before being sent. A killed
+process terminates the next time it waits for a message or a message id.
+
+The requests are not authenticated: whoever can connect can read the
+attributes of the component, run its behaviours and send messages on its
+behalf. Hence by default the control server only listens on the loopback
+interface; expose it (see NewControlServerAt) only on trusted networks.
+*/
+type ControlServer struct {
+    comp *Component
+    address string
+    port int
+    listenerConns *unboundChanConn
+    lock *sync.Mutex
+    spawned map[int]*Process
+    nextPid int
+}
+
+/*
+NewControlServer starts listening on port of the loopback interface (0 picks a
+free port) for control requests directed to the component c. It panics if it
+cannot listen.
+*/
+func NewControlServer(c *Component, port int) *ControlServer {
+    cs, err := NewControlServerAt(c, "127.0.0.1:" + itoa(port))
+    if err != nil {
+        panic(err)
+    }
+    return cs
+}
+
+/*
+NewControlServerAt starts listening on address, e.g. "10.0.0.5:7000" or
+":7000" for all the interfaces, for control requests directed to the component
+c. Since the requests are not authenticated, the address should not be
+reachable from untrusted networks.
+*/
+func NewControlServerAt(c *Component, address string) (*ControlServer, error) {
+    listenerConns, addr, err := listenerAt(address)
+    if err != nil {
+        return nil, err
+    }
+    return &ControlServer{
+        comp: c,
+        address: addr.String(),
+        port: atoi(newNetAddress(addr.String()).Port),
+        listenerConns: listenerConns,
+        lock: &sync.Mutex{},
+        spawned: map[int]*Process{},
+    }, nil
+}
+
+/*
+GetPort returns the port the control server listens on.
+*/
+func (cs *ControlServer) GetPort() int {
+    return cs.port
+}
+
+/*
+GetAddress returns the address the control server listens on.
+*/
+func (cs *ControlServer) GetAddress() string {
+    return cs.address
+}
+
+func (cs *ControlServer) WorkLoop() {
+    for {
+        conn := <- cs.listenerConns.Out
+        go func(c *duplexConn){cs.handleConn(c)}(conn)
+    }
+}
+
+func (cs *ControlServer) handleConn(conn *duplexConn) {
+    defer conn.Close()
+    for {
+        cmd, params, err := conn.ReceiveErr()
+        if err != nil {
+            return
+        }
+        // a failing request gets an error, the connection stays open
+        if !catchPanic("the control server", cs.comp.reportError, func() {
+            cs.serve(conn, cmd, params)
+        }) {
+            conn.Send("ERR", cmd+": internal error")
+        }
+    }
+}
+
+// serve answers the request cmd params received on conn.
+func (cs *ControlServer) serve(conn *duplexConn, cmd string, params []string) {
+    switch cmd {
+        case "GET":
+            if len(params) != 1 {
+                conn.Send("ERR", "usage: GET name")
+                return
+            }
+            val, has := cs.get(params[0])
+            if has {
+                conn.Send("VALUE", encodeTypedValue(val))
+            } else {
+                conn.Send("UNSET")
+            }
+        case "BEHAVIOURS":
+            conn.Send(append([]string{"BEHAVIOURS"}, cs.comp.behaviours.Names()...)...)
+        case "SPAWN":
+            if len(params) != 1 {
+                conn.Send("ERR", "usage: SPAWN name")
+                return
+            }
+            procFnc, has := cs.comp.behaviours.Get(params[0])
+            if !has {
+                conn.Send("ERR", params[0]+": no such behaviour")
+                return
+            }
+            conn.Send("OK", itoa(cs.spawn(procFnc)))
+        case "KILL":
+            if len(params) != 1 {
+                conn.Send("ERR", "usage: KILL pid")
+                return
+            }
+            if pid, err := strconv.Atoi(params[0]); err != nil || !cs.kill(pid) {
+                conn.Send("ERR", params[0]+": no such process")
+            } else {
+                conn.Send("OK")
+            }
+        case "SEND":
+            if len(params) < 1 {
+                conn.Send("ERR", "usage: SEND pred value...")
+                return
+            }
+            cpred, err := ToPredicate(params[0])
+            pred, isPred := cpred.(Predicate)
+            if err != nil || !isPred {
+                conn.Send("ERR", params[0]+": invalid predicate")
+                return
+            }
+            elems := make([]interface{}, len(params)-1)
+            valid := true
+            for i, tv := range params[1:] {
+                if elems[i], valid = decodeTypedValue(tv); !valid {
+                    conn.Send("ERR", tv+": invalid value")
+                    break
+                }
+            }
+            if valid {
+                if err := cs.comp.sendOnce(NewTuple(elems...), pred); err != nil {
+                    conn.Send("ERR", err.Error())
+                } else {
+                    conn.Send("OK")
+                }
+            }
+        default:
+            conn.Send("ERR", cmd+": unknown command")
+    }
+}
+
+// spawn runs procFnc in a new process of the component and returns its pid.
+func (cs *ControlServer) spawn(procFnc func(p *Process)) int {
+    cs.lock.Lock()
+    pid := cs.nextPid
+    cs.nextPid++
+    q := NewProcess(cs.comp)
+    cs.spawned[pid] = q
+    cs.lock.Unlock()
+    q.Run(func(p *Process) {
+        defer func() {
+            cs.lock.Lock()
+            delete(cs.spawned, pid)
+            cs.lock.Unlock()
+        }()
+        procFnc(p)
+    })
+    return pid
+}
+
+// kill kills the process pid, and returns false if it is not running.
+func (cs *ControlServer) kill(pid int) bool {
+    cs.lock.Lock()
+    defer cs.lock.Unlock()
+    q, has := cs.spawned[pid]
+    if has {
+        q.kill()
+    }
+    return has
+}
+
+// get reads the attribute from a process, so that the read is atomic with
+// respect to the other processes of the component.
+func (cs *ControlServer) get(name string) (interface{}, bool) {
+    var val interface{}
+    var has bool
+    done := make(chan struct{})
+    NewProcess(cs.comp).Run(func(p *Process) {
+        p.Set(func(attr *Attributes) {
+            val, has = attr.Get(name)
+        })
+        close(done)
+    })
+    <-done
+    return val, has
+}
+
+func encodeTypedValue(x interface{}) string {
+    switch val := x.(type) {
+        case Tuple:
+            return "T|" + val.encode()
+        case string:
+            return "S|" + val
+        case int:
+            return "I|" + itoa(val)
+        case bool:
+            if val {
+                return "B|true"
+            } else {
+                return "B|false"
+            }
+        default:
+            return "X"
+    }
+}
+
+func decodeTypedValue(s string) (interface{}, bool) {
+    if strings.HasPrefix(s, "S|") {
+        return s[2:], true
+    } else if strings.HasPrefix(s, "I|") {
+        n, err := strconv.Atoi(s[2:])
+        return n, err == nil
+    } else if s == "B|true" {
+        return true, true
+    } else if s == "B|false" {
+        return false, true
+    } else if strings.HasPrefix(s, "T|") {
+        t, err := decodeTupleErr(s[2:])
+        return t, err == nil
+    } else {
+        return nil, false
+    }
+}
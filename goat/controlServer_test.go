@@ -0,0 +1,100 @@
+package goat
+
+import (
+    "testing"
+    "time"
+)
+
+func TestDecodeTypedValue(t *testing.T) {
+    for _, s := range []string{"I|abc", "I|", "T|garbage", "X"} {
+        if _, valid := decodeTypedValue(s); valid {
+            t.Error(s, "accepted")
+        }
+    }
+    tpl := NewTuple("a", 1)
+    if val, valid := decodeTypedValue(encodeTypedValue(tpl)); !valid || ToString(val) != ToString(tpl) {
+        t.Error(val, valid)
+    }
+}
+
+func TestControlServer(t *testing.T) {
+    server := testServer(17720)
+    c := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"role": "ctl"})
+    listener := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"role": "listener"})
+    got := make(chan Tuple, 1)
+    listener.Start(func(p *Process) {
+        got <- p.Receive(func(*Attributes, Tuple) bool { return true })
+    })
+    c.Behaviours().Register("idle", func(p *Process) {
+        p.Receive(func(*Attributes, Tuple) bool { return false })
+    })
+    cs := NewControlServer(c, 0)
+    go cs.WorkLoop()
+    if cs.GetAddress() != "127.0.0.1:" + itoa(cs.GetPort()) {
+        t.Error("the control server must listen on the loopback interface by default, not on", cs.GetAddress())
+    }
+    conn := connectWith(cs.GetAddress())
+    defer conn.Close()
+    request := func(tokens ...string) (string, []string) {
+        conn.Send(tokens...)
+        return conn.Receive()
+    }
+
+    if cmd, params := request("GET", "role"); cmd != "VALUE" || params[0] != "S|ctl" {
+        t.Error(cmd, params)
+    }
+    pred := Equals(Receiver("role"), "listener").CloseUnder(NewAttributes()).String()
+    if cmd, _ := request("SEND", pred, "T|garbage"); cmd != "ERR" {
+        t.Error("invalid tuple accepted")
+    }
+    if cmd, _ := request("SEND", pred, "I|abc"); cmd != "ERR" {
+        t.Error("invalid int accepted")
+    }
+    if cmd, params := request("SEND", pred, "I|7"); cmd != "OK" {
+        t.Error(cmd, params)
+    }
+    select {
+        case msg := <-got:
+            if !msg.IsLong(1) || msg.Get(0) != 7 {
+                t.Error(msg)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("message not delivered")
+    }
+
+    cmd, params := request("SPAWN", "idle")
+    if cmd != "OK" || len(params) != 1 {
+        t.Fatal(cmd, params)
+    }
+    if cmd, _ := request("KILL", params[0]); cmd != "OK" {
+        t.Error("cannot kill", params[0])
+    }
+    deadline := time.Now().Add(5 * time.Second)
+    for cs.kill(atoi(params[0])) {
+        if time.Now().After(deadline) {
+            t.Fatal("the killed process is still running")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    if cmd, _ := request("KILL", params[0]); cmd != "ERR" {
+        t.Error("killed twice")
+    }
+}
+
+func TestControlServerAt(t *testing.T) {
+    c := NewComponent(NewSingleServerAgent(testServer(17720)), nil)
+    if _, err := NewControlServerAt(c, "127.0.0.1:99999"); err == nil {
+        t.Error("an invalid address must give an error")
+    }
+    cs, err := NewControlServerAt(c, "localhost:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    go cs.WorkLoop()
+    conn := connectWith(cs.GetAddress())
+    defer conn.Close()
+    conn.Send("BEHAVIOURS")
+    if cmd, _ := conn.Receive(); cmd != "BEHAVIOURS" {
+        t.Error(cmd)
+    }
+}
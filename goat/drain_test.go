@@ -0,0 +1,51 @@
+package goat
+
+import (
+    "context"
+    "testing"
+    "time"
+)
+
+func TestDrainClosesAgent(t *testing.T) {
+    server := testServer(17730)
+    a := NewComponent(NewSingleServerAgent(server), nil)
+    b := NewComponent(NewSingleServerAgent(server), nil)
+    got := make(chan Tuple, 1)
+    b.Start(func(p *Process) {
+        got <- p.Receive(func(*Attributes, Tuple) bool { return true })
+    })
+    a.Start(func(p *Process) {
+        p.Send(NewTuple("last"), True())
+    })
+    if msg := <-got; msg.Get(0) != "last" {
+        t.Fatal(msg)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+    if err := b.Drain(ctx); err != nil {
+        t.Fatal(err)
+    }
+    select {
+        case <-b.Stopped():
+        default:
+            t.Fatal("a drained component is stopped")
+    }
+    bid := b.GetAgent().GetComponentId()
+    for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+        status, err := QueryCentralServer(server)
+        if err != nil {
+            t.Fatal(err)
+        }
+        connected := false
+        for _, comp := range status.Components {
+            connected = connected || comp.Id == bid
+        }
+        if !connected {
+            break
+        }
+        if time.Now().After(deadline) {
+            t.Fatal("the server did not forget the drained component")
+        }
+    }
+}
@@ -0,0 +1,141 @@
+package goat
+
+import (
+    "errors"
+    "fmt"
+    "runtime/debug"
+)
+
+/*
+The errors reported by the library. The errors surfaced on Component.Errors and
+returned by the API wrap them, hence they can be tested with errors.Is.
+*/
+var (
+    // ErrDisconnected is reported when the connection to the infrastructure is lost.
+    ErrDisconnected = errors.New("goat: disconnected from the infrastructure")
+    // ErrSendTimeout is reported when a message cannot be sent in time.
+    ErrSendTimeout = errors.New("goat: send timed out")
+    // ErrInvalidPredicate is reported when an encoded predicate cannot be decoded.
+    ErrInvalidPredicate = errors.New("goat: invalid predicate")
+    // ErrEnvelopeTooLarge is reported when a message exceeds the maximum size
+    // set with Component.SetMaxEnvelopeSize.
+    ErrEnvelopeTooLarge = errors.New("goat: message too large")
+    // ErrDuplicateIdentity is reported when the component is rejected, or
+    // fenced, because another session registered with the same identity. The
+    // component is stopped.
+    ErrDuplicateIdentity = errors.New("goat: duplicate component identity")
+    // ErrRateLimited is returned when a send exceeds the rate set with
+    // Component.SetSendRate.
+    ErrRateLimited = errors.New("goat: send rate exceeded")
+    // ErrQueryTimeout is returned when the infrastructure does not answer a
+    // query (e.g. Component.Count) in time.
+    ErrQueryTimeout = errors.New("goat: query timed out")
+    // ErrUnknownBehaviour is returned, or reported, when a behaviour is
+    // referred to by a name that is not registered (see BehaviourRegistry).
+    ErrUnknownBehaviour = errors.New("goat: no such behaviour")
+    // ErrInvalidFrame is returned when a protocol line cannot be converted to
+    // or from its protobuf form (see EncodeFrame).
+    ErrInvalidFrame = errors.New("goat: invalid protocol frame")
+)
+
+/*
+ErrorReportingAgent is implemented by the agents that can report asynchronous
+failures; the component sets the handler before starting the agent.
+*/
+type ErrorReportingAgent interface {
+    SetErrorHandler(handler func(error))
+}
+
+// errorsBuffer is the number of errors kept by a component that nobody reads.
+const errorsBuffer = 64
+
+/*
+Errors returns the channel where the asynchronous failures of c (and of its
+agent) are reported. If nobody reads it, the most recent errors are dropped.
+*/
+func (c *Component) Errors() <-chan error {
+    return c.chnErrors
+}
+
+/*
+Stopped returns a channel that is closed if c stops working because of a panic
+in one of its internal goroutines (reported as a PanicError on Errors), because
+another session registered with its identity (ErrDuplicateIdentity) or because
+it has been drained (see Drain). A stopped component does not deliver
+nor send messages anymore.
+*/
+func (c *Component) Stopped() <-chan struct{} {
+    return c.chnStopped
+}
+
+func (c *Component) reportError(err error) {
+    reportErrorTo(c.chnErrors, err)
+    if errors.Is(err, ErrDuplicateIdentity) {
+        c.stopOnce.Do(func() {
+            close(c.chnStopped)
+        })
+    }
+}
+
+func reportErrorTo(chnErrors chan error, err error) {
+    select {
+        case chnErrors <- err:
+        default:
+            dprintln("error dropped:", err)
+    }
+}
+
+/*
+PanicError is reported when a panic is recovered in a goroutine of the library
+or in a function provided by the user (a process, a guard, an accept or an
+update function).
+*/
+type PanicError struct {
+    Where string
+    Value interface{}
+    Stack []byte
+}
+
+func (pe *PanicError) Error() string {
+    return fmt.Sprintf("goat: panic in %s: %v", pe.Where, pe.Value)
+}
+
+// recoverTo must be deferred: it turns a panic into a PanicError for report.
+// Without report, the panic goes on.
+func recoverTo(where string, report func(error)) {
+    if r := recover(); r != nil {
+        if report == nil {
+            panic(r)
+        }
+        report(&PanicError{where, r, debug.Stack()})
+    }
+}
+
+// catchPanic runs fnc, and returns false if it panicked (reporting it).
+func catchPanic(where string, report func(error), fnc func()) (ok bool) {
+    defer func() {
+        if r := recover(); r != nil {
+            report(&PanicError{where, r, debug.Stack()})
+            ok = false
+        }
+    }()
+    fnc()
+    return true
+}
+
+// decodePredicate decodes the predicate of an incoming message; invalid
+// predicates are reported to onError and replaced by False.
+func decodePredicate(s string, onError func(error)) ClosedPredicate {
+    pred, err := ToPredicate(s)
+    if err != nil {
+        if onError != nil {
+            onError(err)
+        }
+        return False()
+    }
+    return pred
+}
+
+func invalidPredicate(s string) error {
+    return fmt.Errorf("%w: %q", ErrInvalidPredicate, s)
+}
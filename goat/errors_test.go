@@ -0,0 +1,69 @@
+package goat
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestToPredicateInvalid(t *testing.T) {
+    for _, s := range []string{"", "?", "=(", "=(I|1", "&(TT", "&(TT,FF", "!(TT", "TTFF", "=(Q|1,I|2)", "=(B|maybe,I|2)", "=(T|!!,I|2)"} {
+        if p, err := ToPredicate(s); !errors.Is(err, ErrInvalidPredicate) {
+            t.Fatal(s, "must be invalid, got", p, err)
+        }
+    }
+    for _, p := range []ClosedPredicate{True(), And(Equals(Receiver("a"), 1), Not(False())).CloseUnder(nil)} {
+        if decoded, err := ToPredicate(p.String()); err != nil || decoded.String() != p.String() {
+            t.Fatal(p, "must be decoded, got", decoded, err)
+        }
+    }
+}
+
+func TestDecodePredicateFallback(t *testing.T) {
+    var reported error
+    pred := decodePredicate("&(", func(err error) {
+        reported = err
+    })
+    if pred.Satisfy(getPrebuiltAttrs()) || !errors.Is(reported, ErrInvalidPredicate) {
+        t.Fatal("an invalid predicate must be reported and satisfied by none")
+    }
+}
+
+func TestCatchPanic(t *testing.T) {
+    var reported error
+    report := func(err error) {
+        reported = err
+    }
+    if !catchPanic("test", report, func() {}) || reported != nil {
+        t.Fatal("no panic must be reported")
+    }
+    if catchPanic("test", report, func() { panic("boom") }) {
+        t.Fatal("the panic must be caught")
+    }
+    var pe *PanicError
+    if !errors.As(reported, &pe) || pe.Where != "test" || pe.Value != "boom" {
+        t.Fatal("the panic must be reported", reported)
+    }
+}
+
+func TestServerUnreachable(t *testing.T) {
+    // no server listens on the port
+    agent := NewSingleServerAgent("127.0.0.1:17747")
+    reported := make(chan error, errorsBuffer)
+    agent.SetErrorHandler(func(err error) {
+        reported <- err
+    })
+    started := make(chan interface{}, 1)
+    go func() {
+        agent.Start()
+        started <- true
+    }()
+    within(t, started, "Start blocked on an unreachable server")
+    select {
+        case err := <-reported:
+            if !errors.Is(err, ErrDisconnected) {
+                t.Error(err)
+            }
+        default:
+            t.Error("the unreachable server was not reported")
+    }
+}
@@ -0,0 +1,136 @@
+package goat
+
+/*
+GroupsAttribute is the attribute where a component keeps the names of the
+groups it belongs to, as a Tuple.
+*/
+const GroupsAttribute = "groups"
+
+/*
+Group is a named group of components. A component can join it only when its
+attributes satisfy Membership, and it leaves it automatically as soon as they
+do not satisfy it anymore.
+*/
+type Group struct {
+    Name string
+    Membership Predicate
+}
+
+/*
+NewGroup defines the group name. If membership is nil, any component can join.
+*/
+func NewGroup(name string, membership Predicate) Group {
+    if membership == nil {
+        membership = True()
+    }
+    return Group{name, membership}
+}
+
+/*
+Members is the predicate satisfied by the members of g, to be used as the
+predicate of a send.
+*/
+func (g Group) Members() Predicate {
+    return Belong(g.Name, Receiver(GroupsAttribute))
+}
+
+/*
+IsMember is the predicate satisfied when the component itself is a member of g,
+to be used in awareness conditions.
+*/
+func (g Group) IsMember() Predicate {
+    return isMember{g.Name}
+}
+
+// isMember is like Belong(name, Comp(GroupsAttribute)), but it does not need
+// the attribute to be set.
+type isMember struct {
+    name string
+}
+
+func (im isMember) CloseUnder(attr *Attributes) ClosedPredicate {
+    if groupsOf(attr).Contains(im.name) {
+        return True()
+    }
+    return False()
+}
+
+func groupsOf(attr *Attributes) Tuple {
+    if groups, has := attr.Get(GroupsAttribute); has {
+        if tGroups, isTuple := groups.(Tuple); isTuple {
+            return tGroups
+        }
+    }
+    return NewTuple()
+}
+
+func addGroup(attr *Attributes, name string) {
+    groups := groupsOf(attr)
+    if !groups.Contains(name) {
+        attr.Set(GroupsAttribute, NewTuple(append(append([]interface{}{}, groups.Elems...), name)...))
+    }
+}
+
+func removeGroup(attr *Attributes, name string) {
+    groups := groupsOf(attr)
+    left := NewTuple()
+    for _, elem := range groups.Elems {
+        if elem != name {
+            left.Append(elem)
+        }
+    }
+    attr.Set(GroupsAttribute, left)
+}
+
+/*
+Join adds the component of p to g, if its attributes satisfy the membership
+predicate of g. It returns whether the component is now a member.
+*/
+func (p *Process) Join(g Group) bool {
+    joined := false
+    p.Set(func(attr *Attributes) {
+        joined = g.Membership.CloseUnder(attr).Satisfy(attr)
+        if joined {
+            addGroup(attr, g.Name)
+        }
+    })
+    if joined {
+        p.Spawn(func(q *Process) {
+            // removes the component when it no longer satisfies the membership,
+            // unless it left the group in the meantime
+            q.Select(
+                Case(Not(g.IsMember()), ThenSend(NewTuple(), False().CloseUnder(nil)), ZeroProcess),
+                Case(Not(g.Membership), ThenSendUpdate(NewTuple(), False().CloseUnder(nil), func(attr *Attributes) {
+                    removeGroup(attr, g.Name)
+                }), ZeroProcess),
+            )
+        })
+    }
+    return joined
+}
+
+/*
+Leave removes the component of p from g.
+*/
+func (p *Process) Leave(g Group) {
+    p.Set(func(attr *Attributes) {
+        removeGroup(attr, g.Name)
+    })
+}
+
+/*
+GroupSend sends msg to the members of g.
+*/
+func (p *Process) GroupSend(g Group, msg Tuple) {
+    p.Send(msg, g.Members())
+}
+
+/*
+GroupReceive behaves like Receive, but only accepts messages while the
+component is a member of g.
+*/
+func (p *Process) GroupReceive(g Group, accept func(attr *Attributes, msg Tuple) bool) Tuple {
+    return p.Receive(func(attr *Attributes, msg Tuple) bool {
+        return groupsOf(attr).Contains(g.Name) && accept(attr, msg)
+    })
+}
@@ -0,0 +1,70 @@
+package goat
+
+import (
+    "testing"
+    "time"
+)
+
+func TestGroupMembership(t *testing.T) {
+    attr := getPrebuiltAttrs()
+    g := NewGroup("g", nil)
+    if g.IsMember().CloseUnder(attr).Satisfy(attr) {
+        t.Fatal("not joined yet")
+    }
+    addGroup(attr, "g")
+    addGroup(attr, "h")
+    addGroup(attr, "g")
+    if groupsOf(attr).Length() != 2 {
+        t.Fatal("groups must not be repeated", groupsOf(attr))
+    }
+    if !g.IsMember().CloseUnder(attr).Satisfy(attr) || !g.Members().CloseUnder(attr).Satisfy(attr) {
+        t.Fatal("joined")
+    }
+    removeGroup(attr, "g")
+    if g.Members().CloseUnder(attr).Satisfy(attr) || !groupsOf(attr).Contains("h") {
+        t.Fatal("left g only", groupsOf(attr))
+    }
+}
+
+func TestJoinLeavesAutomatically(t *testing.T) {
+    server := testServer(17728)
+    c := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"role": "member"})
+    g := NewGroup("g", Equals(Comp("role"), "member"))
+    h := NewGroup("h", nil)
+    joined := make(chan bool, 2)
+    left := make(chan struct{})
+    c.Start(func(p *Process) {
+        joined <- p.Join(g)
+        joined <- p.Join(h)
+        p.Set(func(attr *Attributes) {
+            attr.Set("role", "guest")
+        })
+        p.WaitUntilTrue(func(attr *Attributes) bool {
+            return !groupsOf(attr).Contains("g")
+        })
+        close(left)
+    })
+    if !<-joined || !<-joined {
+        t.Fatal("the membership was satisfied")
+    }
+    select {
+        case <-left:
+        case <-time.After(5 * time.Second):
+            t.Fatal("the component did not leave g when it stopped satisfying its membership")
+    }
+
+    done := make(chan Tuple)
+    c.Start(func(p *Process) {
+        if p.Join(g) {
+            t.Error("joined g without satisfying its membership")
+        }
+        var groups Tuple
+        p.Set(func(attr *Attributes) {
+            groups = groupsOf(attr)
+        })
+        done <- groups
+    })
+    if groups := <-done; groups.Contains("g") || !groups.Contains("h") {
+        t.Error("only g must be left:", groups)
+    }
+}
@@ -0,0 +1,77 @@
+package goat
+
+type handlerActionKind int
+
+const (
+    ignoreHandlerAction handlerActionKind = iota
+    acceptHandlerAction handlerActionKind = iota
+    stopHandlerAction handlerActionKind = iota
+)
+
+/*
+Action is what a handler (see Component.Handle) does with the message it is
+offered: Ignore, Accept, Reply or Stop.
+*/
+type Action struct {
+    kind handlerActionKind
+    reply *Tuple
+    replyPred Predicate
+}
+
+// Ignore leaves the message to the other handlers and processes.
+func Ignore() Action {
+    return Action{kind: ignoreHandlerAction}
+}
+
+// Accept consumes the message; the changes the handler made to the attributes
+// are committed.
+func Accept() Action {
+    return Action{kind: acceptHandlerAction}
+}
+
+// Reply consumes the message, as Accept, and then sends msg to pred.
+func Reply(msg Tuple, pred Predicate) Action {
+    return Action{kind: acceptHandlerAction, reply: &msg, replyPred: pred}
+}
+
+// Stop consumes the message, as Accept, and removes the handler.
+func Stop() Action {
+    return Action{kind: stopHandlerAction}
+}
+
+/*
+Handle offers the messages delivered to c to handler while guard holds on the
+attributes of c, for the simple cases where attribute-based publish/subscribe
+is enough. A handler handles one message at a time and, since it runs while the
+message is being delivered, it must not send (e.g. with c.Send): it replies by
+returning Reply instead. Different handlers run concurrently, as processes do.
+*/
+func (c *Component) Handle(guard Predicate, handler func(msg Tuple, attr *Attributes) Action) {
+    c.Start(func(p *Process) {
+        for {
+            var action Action
+            p.Receive(func(attr *Attributes, msg Tuple) bool {
+                if !guard.CloseUnder(attr).Satisfy(attr) {
+                    return false
+                }
+                action = handler(msg, attr)
+                return action.kind != ignoreHandlerAction
+            })
+            if action.reply != nil {
+                p.Send(*action.reply, action.replyPred)
+            }
+            if action.kind == stopHandlerAction {
+                return
+            }
+        }
+    })
+}
+
+/*
+Send sends msg to the components satisfying pred from outside processes, and
+returns once it has been sent. It fails with ErrSendTimeout if a send timeout
+is set (see SetSendTimeout) and expires.
+*/
+func (c *Component) Send(msg Tuple, pred Predicate) error {
+    return c.sendOnce(msg, pred)
+}
@@ -0,0 +1,107 @@
+package goat
+
+import (
+    "testing"
+    "time"
+)
+
+func TestHandle(t *testing.T) {
+    server := testServer(17736)
+    h := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "h", "ready": false, "n": 0})
+    client := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"role": "client"})
+    handled := make(chan string, 10)
+    h.Handle(Equals(Comp("ready"), true), func(msg Tuple, attr *Attributes) Action {
+        handled <- msg.Get(0).(string)
+        switch msg.Get(0) {
+            case "accept":
+                attr.Set("n", attr.GetValue("n").(int) + 1)
+                return Accept()
+            case "reply":
+                return Reply(NewTuple("pong"), Equals(Receiver("role"), "client"))
+            case "stop":
+                return Stop()
+            default:
+                return Ignore()
+        }
+    })
+    // the messages the handler ignores are left to the others
+    unhandled := make(chan string, 10)
+    h.SetDeadLetterHandler(func(dl DeadLetter) {
+        if dl.Satisfied {
+            unhandled <- dl.Message.Get(0).(string)
+        }
+    })
+    replies := make(chan Tuple, 1)
+    client.Start(func(p *Process) {
+        replies <- p.Receive(func(*Attributes, Tuple) bool { return true })
+    })
+    toH := Equals(Receiver("name"), "h")
+    send := func(msg string) {
+        if err := client.Send(NewTuple(msg), toH); err != nil {
+            t.Fatal(err)
+        }
+    }
+    expect := func(chn chan string, what string, msg string) {
+        select {
+            case got := <-chn:
+                if got != msg {
+                    t.Fatal(what, got, "instead of", msg)
+                }
+            case <-time.After(5 * time.Second):
+                t.Fatal(msg, "not", what)
+        }
+    }
+    attr := func(name string) interface{} {
+        var val interface{}
+        done := make(chan struct{})
+        h.Start(func(p *Process) {
+            p.Set(func(attr *Attributes) {
+                val = attr.GetValue(name)
+            })
+            close(done)
+        })
+        <-done
+        return val
+    }
+
+    send("accept")
+    expect(unhandled, "left", "accept")
+    ready := make(chan struct{})
+    h.Start(func(p *Process) {
+        p.Set(func(attr *Attributes) {
+            attr.Set("ready", true)
+        })
+        close(ready)
+    })
+    <-ready
+    send("ignore")
+    expect(handled, "handled", "ignore")
+    expect(unhandled, "left", "ignore")
+    send("accept")
+    expect(handled, "handled", "accept")
+    if n := attr("n"); n != 1 {
+        t.Error("the update of an accepting handler must be committed, n =", n)
+    }
+    send("reply")
+    expect(handled, "handled", "reply")
+    select {
+        case reply := <-replies:
+            if reply.Get(0) != "pong" {
+                t.Error(reply)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("no reply")
+    }
+    send("stop")
+    expect(handled, "handled", "stop")
+    send("accept")
+    expect(unhandled, "left", "accept")
+    select {
+        case msg := <-handled:
+            t.Error("a stopped handler handled", msg)
+        default:
+    }
+    if n := attr("n"); n != 1 {
+        t.Error("n =", n)
+    }
+}
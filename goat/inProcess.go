@@ -1,10 +1,18 @@
 package goat
 
+// drainRequest asks the sequencer to stop after the message id lastMid, and
+// to close chnDrained once it has been served.
+type drainRequest struct {
+    lastMid int
+    chnDrained chan struct{}
+}
+
 type inProcess struct {
     chnRply *unboundChanInt
     chnData *unboundChanMessage
     chnFirstMid chan int
     chnNext chan struct{}
+    chnDrain chan drainRequest
     nid int
     inMessages map[int]Message
     inMids map[int]struct{}
@@ -13,28 +21,33 @@ type inProcess struct {
     chnMessage *unboundChanMessage
 }
 
-func newInProcess(chnRply *unboundChanInt, chnData *unboundChanMessage) *inProcess {
+func newInProcess(chnRply *unboundChanInt, chnData *unboundChanMessage, onPanic func(error)) *inProcess {
     ip := inProcess {chnRply: chnRply,
         chnData: chnData,
         chnFirstMid: make(chan int),
         chnNext: make(chan struct{}),
+        chnDrain: make(chan drainRequest),
         nid: -1,
         inMessages: map[int]Message{},
         inMids: map[int]struct{}{},
         chnFreshMid: newUnboundChanInt(),
         chnMessage: newUnboundChanMessage()}
-    go func(){ip.goroutine()}()
+    go func(){
+        defer recoverTo("the message sequencer", onPanic)
+        ip.goroutine()
+    }()
     return &ip
 }
 
 func (ip *inProcess) goroutine() {
+    var drain *drainRequest
     for{
         select{
             case mid := <- ip.chnRply.Out:
                 ip.inMids[mid] = struct{}{}
             
             case msg := <- ip.chnData.Out:
-                ip.inMessages[msg.Id] = msg
+                ip.inMessages[msg.Id] = unforged(msg)
                 
             case ip.nid = <- ip.chnFirstMid:
             
@@ -43,8 +56,19 @@ func (ip *inProcess) goroutine() {
                 delete(ip.inMids, ip.nid)
                 delete(ip.inMessages, ip.nid)
                 ip.nid++
+            
+            case req := <- ip.chnDrain:
+                drain = &req
         }
         
+        if drain != nil && ip.nid > drain.lastMid {
+            if drain.chnDrained != nil {
+                close(drain.chnDrained)
+                drain.chnDrained = nil
+            }
+            // nothing beyond the frontier is served anymore
+            continue
+        }
         if msg, has := ip.inMessages[ip.nid]; has {
                 delete(ip.inMessages, ip.nid)
             dprintln("Serving <-",ip.nid)
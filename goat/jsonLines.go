@@ -0,0 +1,119 @@
+package goat
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "io"
+    "sync"
+)
+
+/*
+jsonAction is a line read by RunJSONLines. Examples:
+
+    {"action":"send","message":["bid",10],"predicate":"=(A|role,S|seller)"}
+    {"action":"set","attributes":{"load":3}}
+*/
+type jsonAction struct {
+    Action string `json:"action"`
+    Message json.RawMessage `json:"message"`
+    Predicate string `json:"predicate"`
+    Attributes map[string]interface{} `json:"attributes"`
+}
+
+type jsonOutput struct {
+    Message json.RawMessage `json:"message,omitempty"`
+    Error string `json:"error,omitempty"`
+}
+
+/*
+RunJSONLines drives the component c through JSON lines. Every line read from
+in is an action: "send" sends message (a JSON array) to the components
+satisfying predicate (encoded as by ClosedPredicate.String), "set" updates
+the given attributes atomically. Every message delivered to c is written on
+out as {"message":[...]}, and every invalid action as {"error":"..."}.
+RunJSONLines returns when in is exhausted.
+*/
+func RunJSONLines(c *Component, in io.Reader, out io.Writer) error {
+    lock := &sync.Mutex{}
+    write := func(o jsonOutput) {
+        line, _ := json.Marshal(o)
+        lock.Lock()
+        out.Write(append(line, '\n'))
+        lock.Unlock()
+    }
+    c.Start(func(p *Process) {
+        for {
+            msg := p.Receive(func(*Attributes, Tuple) bool {
+                return true
+            })
+            data, err := EncodeTupleJSON(msg)
+            if err != nil {
+                write(jsonOutput{Error: err.Error()})
+            } else {
+                write(jsonOutput{Message: data})
+            }
+        }
+    })
+
+    scanner := bufio.NewScanner(in)
+    for scanner.Scan() {
+        if len(scanner.Bytes()) == 0 {
+            continue
+        }
+        var act jsonAction
+        dec := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+        // as in DecodeTupleJSON, large integers must not lose precision
+        dec.UseNumber()
+        if err := dec.Decode(&act); err != nil {
+            write(jsonOutput{Error: err.Error()})
+            continue
+        }
+        switch act.Action {
+            case "send":
+                msg, err := DecodeTupleJSON(act.Message)
+                if err != nil {
+                    write(jsonOutput{Error: err.Error()})
+                    continue
+                }
+                if act.Predicate == "" {
+                    write(jsonOutput{Error: "missing predicate"})
+                    continue
+                }
+                cpred, err := ToPredicate(act.Predicate)
+                pred, isPred := cpred.(Predicate)
+                if err != nil || !isPred {
+                    write(jsonOutput{Error: act.Predicate + ": invalid predicate"})
+                    continue
+                }
+                if err := c.sendOnce(msg, pred); err != nil {
+                    write(jsonOutput{Error: err.Error()})
+                }
+            case "set":
+                values := map[string]interface{}{}
+                var err error
+                for k, v := range act.Attributes {
+                    if values[k], err = jsonValueToGo(v); err != nil {
+                        break
+                    }
+                }
+                if err != nil {
+                    write(jsonOutput{Error: err.Error()})
+                    continue
+                }
+                done := make(chan struct{})
+                NewProcess(c).Run(func(p *Process) {
+                    p.Set(func(attr *Attributes) {
+                        for k, v := range values {
+                            attr.Set(k, v)
+                        }
+                    })
+                    close(done)
+                })
+                <-done
+            default:
+                write(jsonOutput{Error: act.Action + ": unknown action"})
+        }
+    }
+    return scanner.Err()
+}
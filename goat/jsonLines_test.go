@@ -0,0 +1,72 @@
+package goat
+
+import (
+    "bufio"
+    "encoding/json"
+    "io"
+    "testing"
+    "time"
+)
+
+func TestRunJSONLines(t *testing.T) {
+    server := testServer(17724)
+    c := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "script"})
+    echo := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "echo"})
+    echo.Start(func(p *Process) {
+        for {
+            msg := p.Receive(func(*Attributes, Tuple) bool { return true })
+            p.Send(msg, Equals(Receiver("load"), 3))
+        }
+    })
+    inR, inW := io.Pipe()
+    outR, outW := io.Pipe()
+    go RunJSONLines(c, inR, outW)
+    lines := bufio.NewScanner(outR)
+    next := func() jsonOutput {
+        chnOut := make(chan jsonOutput)
+        go func() {
+            var o jsonOutput
+            if lines.Scan() {
+                json.Unmarshal(lines.Bytes(), &o)
+            }
+            chnOut <- o
+        }()
+        select {
+            case o := <-chnOut:
+                return o
+            case <-time.After(5 * time.Second):
+                t.Fatal("no output")
+                return jsonOutput{}
+        }
+    }
+    toEcho := Equals(Receiver("name"), "echo").CloseUnder(NewAttributes()).String()
+    predJSON, _ := json.Marshal(toEcho)
+
+    io.WriteString(inW, `{"action":"send","message":[{"a":1}],"predicate":`+string(predJSON)+"}\n")
+    if o := next(); o.Error == "" {
+        t.Error("invalid message accepted")
+    }
+    io.WriteString(inW, `{"action":"set","attributes":{"load":{}}}`+"\n")
+    if o := next(); o.Error == "" {
+        t.Error("invalid attribute accepted")
+    }
+    io.WriteString(inW, `{"action":"jump"}`+"\n")
+    if o := next(); o.Error == "" {
+        t.Error("unknown action accepted")
+    }
+    io.WriteString(inW, `{"action":"set","attributes":{"load":3,"serial":9007199254740993}}`+"\n")
+    io.WriteString(inW, `{"action":"send","message":["ping",1],"predicate":`+string(predJSON)+"}\n")
+    if o := next(); o.Error != "" || string(o.Message) != `["ping",1]` {
+        t.Error(o.Error, string(o.Message))
+    }
+    serial := make(chan interface{})
+    c.Start(func(p *Process) {
+        p.Set(func(attr *Attributes) {
+            serial <- attr.GetValue("serial")
+        })
+    })
+    if serial := <-serial; serial != 9007199254740993 {
+        t.Error("large integers must be set exactly:", serial)
+    }
+    inW.Close()
+}
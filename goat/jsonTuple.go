@@ -0,0 +1,95 @@
+package goat
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math"
+    "strconv"
+)
+
+/*
+EncodeTupleJSON encodes t as a JSON array. Nested tuples become nested arrays.
+*/
+func EncodeTupleJSON(t Tuple) ([]byte, error) {
+    return json.Marshal(tupleToJSONValue(t))
+}
+
+/*
+DecodeTupleJSON decodes a JSON array into a tuple. Integral numbers that fit
+in an int become int, exactly even beyond the precision of float64, other
+numbers float64 and nested arrays nested tuples. A payload that is not an array
+is returned as a one-element tuple. Objects and nulls cannot be sent in a
+message, hence they are rejected.
+*/
+func DecodeTupleJSON(data []byte) (Tuple, error) {
+    dec := json.NewDecoder(bytes.NewReader(data))
+    dec.UseNumber()
+    var v interface{}
+    if err := dec.Decode(&v); err != nil {
+        return NewTuple(), err
+    }
+    if _, err := dec.Token(); err != io.EOF {
+        return NewTuple(), fmt.Errorf("goat: invalid JSON after the value")
+    }
+    if arr, isArr := v.([]interface{}); isArr {
+        return jsonValueToTuple(arr)
+    }
+    elem, err := jsonValueToGo(v)
+    if err != nil {
+        return NewTuple(), err
+    }
+    return NewTuple(elem), nil
+}
+
+func tupleToJSONValue(t Tuple) []interface{} {
+    out := make([]interface{}, len(t.Elems))
+    for i, e := range t.Elems {
+        if nested, isTuple := e.(Tuple); isTuple {
+            out[i] = tupleToJSONValue(nested)
+        } else {
+            out[i] = e
+        }
+    }
+    return out
+}
+
+func jsonValueToTuple(arr []interface{}) (Tuple, error) {
+    elems := make([]interface{}, len(arr))
+    for i, e := range arr {
+        var err error
+        if elems[i], err = jsonValueToGo(e); err != nil {
+            return NewTuple(), err
+        }
+    }
+    return NewTuple(elems...), nil
+}
+
+func jsonValueToGo(v interface{}) (interface{}, error) {
+    switch val := v.(type) {
+        case json.Number:
+            if n, err := strconv.ParseInt(val.String(), 10, 0); err == nil {
+                return int(n), nil
+            }
+            f, err := val.Float64()
+            if err != nil {
+                return nil, fmt.Errorf("goat: unsupported JSON number %v", val)
+            }
+            return jsonValueToGo(f)
+        case float64:
+            // e.g. 2.0 or 1e3
+            if val == math.Trunc(val) {
+                if n, err := strconv.ParseInt(strconv.FormatFloat(val, 'f', -1, 64), 10, 0); err == nil {
+                    return int(n), nil
+                }
+            }
+            return val, nil
+        case string, bool:
+            return val, nil
+        case []interface{}:
+            return jsonValueToTuple(val)
+        default:
+            return nil, fmt.Errorf("goat: unsupported JSON value %v", val)
+    }
+}
@@ -0,0 +1,45 @@
+package goat
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestTupleJSONRoundTrip(t *testing.T) {
+    t1 := NewTuple("bid", 7, true, 1.5, NewTuple("a", 2))
+    data, err := EncodeTupleJSON(t1)
+    if err != nil || string(data) != `["bid",7,true,1.5,["a",2]]` {
+        t.Fatal(string(data), err)
+    }
+    if t2, err := DecodeTupleJSON(data); err != nil || !reflect.DeepEqual(t1, t2) {
+        t.Error(t2, err)
+    }
+}
+
+func TestTupleJSONScalar(t *testing.T) {
+    if t1, err := DecodeTupleJSON([]byte(`"on"`)); err != nil || !reflect.DeepEqual(t1, NewTuple("on")) {
+        t.Error(t1, err)
+    }
+    if _, err := DecodeTupleJSON([]byte(`[`)); err == nil {
+        t.Error("invalid JSON accepted")
+    }
+}
+
+func TestTupleJSONNumbers(t *testing.T) {
+    t1, err := DecodeTupleJSON([]byte(`[9007199254740993,-9007199254740993,2.0,1e3,2.5,1e100,-0.5]`))
+    want := NewTuple(9007199254740993, -9007199254740993, 2, 1000, 2.5, 1e100, -0.5)
+    if err != nil || !reflect.DeepEqual(t1, want) {
+        t.Error("integers must be decoded exactly:", t1, err)
+    }
+    if t1, err := DecodeTupleJSON([]byte(`[1] [2]`)); err == nil {
+        t.Error("trailing data accepted as", t1)
+    }
+}
+
+func TestTupleJSONUnsupported(t *testing.T) {
+    for _, payload := range []string{`[{"a":1}]`, `[1,null]`, `{"a":1}`, `null`, `[[{}]]`} {
+        if t1, err := DecodeTupleJSON([]byte(payload)); err == nil {
+            t.Error(payload, "accepted as", t1)
+        }
+    }
+}
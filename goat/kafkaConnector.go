@@ -0,0 +1,126 @@
+package goat
+
+import (
+    "fmt"
+)
+
+/*
+KafkaRecord is a record read from or written to a Kafka topic.
+*/
+type KafkaRecord struct {
+    Topic string
+    Key []byte
+    Value []byte
+}
+
+/*
+KafkaConsumer is the subset of a Kafka consumer used by KafkaConnector: it must
+be already subscribed to the ingress topics. ReadRecord blocks until a record
+is available; an error stops the ingress.
+*/
+type KafkaConsumer interface {
+    ReadRecord() (KafkaRecord, error)
+}
+
+/*
+KafkaProducer is the subset of a Kafka producer used by KafkaConnector.
+*/
+type KafkaProducer interface {
+    WriteRecord(KafkaRecord) error
+}
+
+/*
+KafkaConnector is a component that connects a goat system to Kafka. Records
+read from Consumer are decoded as JSON arrays and sent to the predicate
+computed by PredicateTemplate; a record that cannot be decoded (see
+DecodeTupleJSON) is not sent, and the failure is reported on the Errors of the
+component and to OnError. Messages delivered to the component are written to
+OutTopic as JSON arrays, with the key computed by OutKey. A nil Consumer or
+Producer disables the corresponding direction.
+*/
+type KafkaConnector struct {
+    Comp *Component
+    Consumer KafkaConsumer
+    Producer KafkaProducer
+    // PredicateTemplate defaults to True().
+    PredicateTemplate func(rec KafkaRecord, msg Tuple) Predicate
+    OutTopic string
+    // OutKey defaults to a nil key.
+    OutKey func(msg Tuple) []byte
+    // OnError, if set, is called when a record cannot be read or written.
+    OnError func(error)
+}
+
+/*
+KeyEquals returns a predicate template that sends each record to the
+components whose attribute attr is equal to the record key.
+*/
+func KeyEquals(attr string) func(KafkaRecord, Tuple) Predicate {
+    return func(rec KafkaRecord, msg Tuple) Predicate {
+        return Equals(Receiver(attr), string(rec.Key))
+    }
+}
+
+/*
+Start starts both the ingress and the egress of the connector.
+*/
+func (kc *KafkaConnector) Start() {
+    if kc.Consumer != nil {
+        go func(){kc.ingress()}()
+    }
+    if kc.Producer != nil {
+        kc.Comp.Start(func(p *Process) {
+            for {
+                msg := p.Receive(func(*Attributes, Tuple) bool {
+                    return true
+                })
+                kc.egress(msg)
+            }
+        })
+    }
+}
+
+func (kc *KafkaConnector) ingress() {
+    for {
+        rec, err := kc.Consumer.ReadRecord()
+        if err != nil {
+            kc.onError(err)
+            return
+        }
+        msg, err := DecodeTupleJSON(rec.Value)
+        if err != nil {
+            err = fmt.Errorf("goat: undecodable record on %s: %w", rec.Topic, err)
+            kc.Comp.reportError(err)
+            kc.onError(err)
+            continue
+        }
+        var pred Predicate = True()
+        if kc.PredicateTemplate != nil {
+            pred = kc.PredicateTemplate(rec, msg)
+        }
+        if err = kc.Comp.sendOnce(msg, pred); err != nil {
+            kc.onError(err)
+        }
+    }
+}
+
+func (kc *KafkaConnector) egress(msg Tuple) {
+    value, err := EncodeTupleJSON(msg)
+    if err != nil {
+        kc.onError(err)
+        return
+    }
+    rec := KafkaRecord{Topic: kc.OutTopic, Value: value}
+    if kc.OutKey != nil {
+        rec.Key = kc.OutKey(msg)
+    }
+    if err = kc.Producer.WriteRecord(rec); err != nil {
+        kc.onError(err)
+    }
+}
+
+func (kc *KafkaConnector) onError(err error) {
+    if kc.OnError != nil {
+        kc.OnError(err)
+    }
+}
@@ -0,0 +1,90 @@
+package goat
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+// chanKafka is a KafkaConsumer and a KafkaProducer backed by channels.
+type chanKafka struct {
+    in chan KafkaRecord
+    out chan KafkaRecord
+}
+
+func (ck *chanKafka) ReadRecord() (KafkaRecord, error) {
+    rec, open := <-ck.in
+    if !open {
+        return KafkaRecord{}, errors.New("closed")
+    }
+    return rec, nil
+}
+
+func (ck *chanKafka) WriteRecord(rec KafkaRecord) error {
+    ck.out <- rec
+    return nil
+}
+
+func TestKafkaIngress(t *testing.T) {
+    server := testServer(17722)
+    ck := &chanKafka{make(chan KafkaRecord), nil}
+    kc := &KafkaConnector{
+        Comp: NewComponent(NewSingleServerAgent(server), nil),
+        Consumer: ck,
+        PredicateTemplate: KeyEquals("name"),
+    }
+    kc.Start()
+    sink := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "sink"})
+    got := make(chan Tuple, 2)
+    sink.Start(func(p *Process) {
+        for {
+            got <- p.Receive(func(*Attributes, Tuple) bool { return true })
+        }
+    })
+
+    ck.in <- KafkaRecord{Topic: "in", Key: []byte("sink"), Value: []byte(`[{"a":1}]`)}
+    select {
+        case err := <-kc.Comp.Errors():
+            if err == nil {
+                t.Error("nil error")
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("undecodable record not reported")
+    }
+    ck.in <- KafkaRecord{Topic: "in", Key: []byte("other"), Value: []byte(`["lost"]`)}
+    ck.in <- KafkaRecord{Topic: "in", Key: []byte("sink"), Value: []byte(`["reading",21]`)}
+    select {
+        case msg := <-got:
+            if !msg.IsLong(2) || msg.Get(0) != "reading" || msg.Get(1) != 21 {
+                t.Error(msg)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("record not delivered")
+    }
+}
+
+func TestKafkaEgress(t *testing.T) {
+    server := testServer(17723)
+    ck := &chanKafka{nil, make(chan KafkaRecord, 1)}
+    kc := &KafkaConnector{
+        Comp: NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "kafka"}),
+        Producer: ck,
+        OutTopic: "out",
+        OutKey: func(msg Tuple) []byte {
+            return []byte(ToString(msg.Get(0)))
+        },
+    }
+    kc.Start()
+    src := NewComponent(NewSingleServerAgent(server), nil)
+    src.Start(func(p *Process) {
+        p.Send(NewTuple("alarm", 2.5), Equals(Receiver("name"), "kafka"))
+    })
+    select {
+        case rec := <-ck.out:
+            if rec.Topic != "out" || string(rec.Key) != "alarm" || string(rec.Value) != `["alarm",2.5]` {
+                t.Error(rec.Topic, string(rec.Key), string(rec.Value))
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("message not written")
+    }
+}
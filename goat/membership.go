@@ -0,0 +1,105 @@
+package goat
+
+import "errors"
+
+/*
+MembershipAgent is implemented by the agents whose infrastructure notifies the
+components that join and leave it (currently only SingleServerAgent).
+*/
+type MembershipAgent interface {
+    SubscribeMembership()
+}
+
+var errNoMembership = errors.New("the agent does not support membership events")
+
+// The kinds of MembershipEvent.
+const (
+    ComponentJoined = "joined"
+    ComponentLeft = "left"
+)
+
+// membershipTag is the first element of the messages carrying membership events.
+const membershipTag = "goat:membership"
+
+/*
+InfrastructureId is the sender (see Message) of the messages of the
+infrastructure itself, i.e. the membership events: those sent by components are
+ignored.
+*/
+const InfrastructureId = -1
+
+/*
+MembershipEvent tells that the component ComponentId (registered with Identity,
+possibly empty) has joined or left, i.e. disconnected from, the infrastructure.
+*/
+type MembershipEvent struct {
+    Kind string
+    ComponentId int
+    Identity string
+}
+
+func (evt MembershipEvent) tuple() Tuple {
+    return NewTuple(membershipTag, evt.Kind, evt.ComponentId, evt.Identity)
+}
+
+/*
+MembershipEventOf returns the event carried by msg, if it is a membership event.
+The messages received by processes only carry the events sent by the
+infrastructure.
+*/
+func MembershipEventOf(msg Tuple) (MembershipEvent, bool) {
+    if msg.Length() != 4 || msg.Get(0) != membershipTag {
+        return MembershipEvent{}, false
+    }
+    kind, isString := msg.Get(1).(string)
+    cid, isInt := msg.Get(2).(int)
+    identity, isIdentity := msg.Get(3).(string)
+    if !isString || !isInt || !isIdentity {
+        return MembershipEvent{}, false
+    }
+    return MembershipEvent{kind, cid, identity}, true
+}
+
+// unforged returns msg, unless it carries a membership event that was not sent
+// by the infrastructure: such a message is replaced by one that no component
+// accepts, which still takes its place in the total order.
+func unforged(msg Message) Message {
+    if _, isEvent := MembershipEventOf(msg.Message); isEvent && msg.Sender != InfrastructureId {
+        return Message{Id: msg.Id, Message: NewTuple(), Pred: False(), Sender: msg.Sender}
+    }
+    return msg
+}
+
+/*
+SubscribeMembership asks the infrastructure to notify c of the components that
+join and leave it. The events are delivered as messages, in the total order of
+the other messages: first a ComponentJoined event for each component already
+connected, then the later changes. Processes receive them as any other message
+(see MembershipEventOf and Process.ReceiveMembership), hence processes of c that
+accept every message will accept them too.
+*/
+func (c *Component) SubscribeMembership() error {
+    ma, hasMembership := c.agent.(MembershipAgent)
+    if !hasMembership {
+        return errNoMembership
+    }
+    ma.SubscribeMembership()
+    return nil
+}
+
+/*
+ReceiveMembership waits for a membership event accepted by accept (nil accepts
+any event); see Component.SubscribeMembership.
+*/
+func (p *Process) ReceiveMembership(accept func(evt MembershipEvent) bool) MembershipEvent {
+    var evt MembershipEvent
+    p.Receive(func(attr *Attributes, msg Tuple) bool {
+        received, isEvent := MembershipEventOf(msg)
+        if isEvent && (accept == nil || accept(received)) {
+            evt = received
+            return true
+        }
+        return false
+    })
+    return evt
+}
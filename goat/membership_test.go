@@ -0,0 +1,46 @@
+package goat
+
+import (
+    "testing"
+)
+
+func TestForgedMembershipEvent(t *testing.T) {
+    server := testServer(17753)
+    observer := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "observer"})
+    if err := observer.SubscribeMembership(); err != nil {
+        t.Fatal(err)
+    }
+    events := make(chan interface{}, 4)
+    observer.Start(func(p *Process) {
+        for {
+            // any message that looks like an event, not only the valid ones
+            events <- p.Receive(func(attr *Attributes, msg Tuple) bool {
+                return msg.Length() > 0 && msg.Get(0) == membershipTag
+            })
+        }
+    })
+    nextEvent := func(what string) MembershipEvent {
+        evt, isEvent := MembershipEventOf(within(t, events, what).(Tuple))
+        if !isEvent {
+            t.Fatal("not an event")
+        }
+        return evt
+    }
+    forger := NewComponent(NewSingleServerAgent(server), nil)
+    if evt := nextEvent("the forger joining was not notified"); evt.ComponentId != forger.GetAgent().GetComponentId() {
+        t.Fatal(evt)
+    }
+    sent := make(chan interface{}, 1)
+    forger.Start(func(p *Process) {
+        forged := MembershipEvent{ComponentLeft, 42, "forged"}
+        p.Send(forged.tuple(), Equals(Receiver("name"), "observer"))
+        sent <- true
+    })
+    within(t, sent, "the forged event was not sent")
+    // an event of the infrastructure after the forged one
+    late := NewComponent(NewSingleServerAgent(server), nil)
+    evt := nextEvent("the late component joining was not notified")
+    if evt.ComponentId != late.GetAgent().GetComponentId() || evt.Kind != ComponentJoined {
+        t.Error("the forged event must be ignored, got", evt)
+    }
+}
@@ -1,6 +1,38 @@
 package goat
 
 //import "fmt"
+import "math/rand"
+
+/*
+DispatchPolicy states in which order the processes of a component are offered
+the incoming messages (see Component.SetDispatchPolicy).
+*/
+type DispatchPolicy int
+
+const (
+    // DispatchRoundRobin offers each message starting from the process after
+    // the one that accepted the previous message, so that no willing process
+    // starves.
+    DispatchRoundRobin DispatchPolicy = iota
+    // DispatchRandom offers each message in a random order.
+    DispatchRandom DispatchPolicy = iota
+    // DispatchInOrder always offers messages in subscription order (the oldest
+    // process first).
+    DispatchInOrder DispatchPolicy = iota
+)
+
+/*
+DeadLetter is a message that no process of a component accepted (see
+Component.SetDeadLetterHandler). Satisfied tells whether the attributes of
+the component satisfied Pred, the predicate of the sender, when the message
+was offered: if not, no process could accept it.
+*/
+type DeadLetter struct {
+    Id int
+    Message Tuple
+    Pred ClosedPredicate
+    Satisfied bool
+}
 
 type messageDispatcher struct {
     chnMessage *unboundChanMessage
@@ -11,17 +43,24 @@ type messageDispatcher struct {
     attributes *Attributes
     evtMid int
     chnEvtMid chan struct{}
+    chnPolicy chan DispatchPolicy
+    chnDeadLetter chan func(Message)
 }
 
-func newMessageDispatcher(chnMessageIn *unboundChanMessage, chnSubscribe chan []*Process, chnUnsubscribe chan *Process, chnNext chan struct{}, attributes *Attributes)  *messageDispatcher {
+func newMessageDispatcher(chnMessageIn *unboundChanMessage, chnSubscribe chan []*Process, chnUnsubscribe chan *Process, chnNext chan struct{}, attributes *Attributes, onPanic func(error))  *messageDispatcher {
     md := messageDispatcher{chnMessage: chnMessageIn,
         chnSubscribe: chnSubscribe,
         chnUnsubscribe: chnUnsubscribe,
         chnNext: chnNext,
         chnAcceptMessage: make(chan bool),
         attributes: attributes,
-        evtMid: -1}
-    go func(){md.goroutine()}()
+        evtMid: -1,
+        chnPolicy: make(chan DispatchPolicy),
+        chnDeadLetter: make(chan func(Message))}
+    go func(){
+        defer recoverTo("the message dispatcher", onPanic)
+        md.goroutine()
+    }()
     return &md
 }
 
@@ -30,18 +69,73 @@ func (md *messageDispatcher) OnMid(mid int, chnEvt chan struct{}) {
     md.evtMid = mid
 }
 
+func (md *messageDispatcher) SetPolicy(policy DispatchPolicy) {
+    md.chnPolicy <- policy
+}
+
+// SetDeadLetterHandler sets the function called, by the dispatcher, with the
+// messages no process accepted.
+func (md *messageDispatcher) SetDeadLetterHandler(handler func(Message)) {
+    md.chnDeadLetter <- handler
+}
+
+// offerOrder returns the order in which procs (in subscription order) are
+// offered the next message, given that last accepted the previous one.
+func offerOrder(procs []*Process, last *Process, policy DispatchPolicy, rnd *rand.Rand) []*Process {
+    out := make([]*Process, 0, len(procs))
+    switch policy {
+        case DispatchRandom:
+            for _, i := range rnd.Perm(len(procs)) {
+                out = append(out, procs[i])
+            }
+        case DispatchRoundRobin:
+            start := 0
+            for i, p := range procs {
+                if p == last {
+                    start = i + 1
+                }
+            }
+            out = append(append(out, procs[start:]...), procs[:start]...)
+        default:
+            out = append(out, procs...)
+    }
+    return out
+}
+
 func (md *messageDispatcher) goroutine() {
     subscribedProcs := map[*Process]struct{}{}
+    procsOrder := []*Process{}
+    subscribe := func(p *Process) {
+        if _, has := subscribedProcs[p]; !has {
+            subscribedProcs[p] = struct{}{}
+            procsOrder = append(procsOrder, p)
+        }
+    }
+    unsubscribe := func(p *Process) {
+        if _, has := subscribedProcs[p]; has {
+            delete(subscribedProcs, p)
+            for i, q := range procsOrder {
+                if q == p {
+                    procsOrder = append(procsOrder[:i], procsOrder[i+1:]...)
+                    break
+                }
+            }
+        }
+    }
+    policy := DispatchRoundRobin
+    rnd := rand.New(rand.NewSource(timeNow().UnixNano()))
+    var lastAccepting *Process
+    var deadLetter func(Message)
     
     for {
         select{
             case msg := <- md.chnMessage.Out:
-                toSubscribe := map[*Process]struct{}{}
+                toSubscribe := []*Process{}
                 unsubscribedProcs := map[*Process]struct{}{}
                 accepted := false
                 i := 1
                 //fmt.Println("Serving",msg.Id)
-                for p := range subscribedProcs {
+                for _, p := range offerOrder(procsOrder, lastAccepting, policy, rnd) {
                     //fmt.Println("Serving",msg.Id,"to",i,"/",len(subscribedProcs))
                     i++
                     if _, uns := unsubscribedProcs[p]; !accepted && !uns {
@@ -51,9 +145,7 @@ func (md *messageDispatcher) goroutine() {
                             case p.chnMessage <- msg:
                                 quit = true
                             case prs := <- md.chnSubscribe:
-                                for _, pr := range prs{
-                                    toSubscribe[pr] = struct{}{}
-                                }
+                                toSubscribe = append(toSubscribe, prs...)
                             case pr := <- md.chnUnsubscribe:
                                 unsubscribedProcs[pr] = struct{}{}
                                 withdraw = (p == pr)
@@ -71,11 +163,12 @@ func (md *messageDispatcher) goroutine() {
                                     } else {
                                         md.attributes.rollback()
                                     }*/
+                                    if accepted {
+                                        lastAccepting = p
+                                    }
                                     quit = true
                                 case prs := <- md.chnSubscribe:
-                                    for _, pr := range prs{
-                                        toSubscribe[pr] = struct{}{}
-                                    }
+                                    toSubscribe = append(toSubscribe, prs...)
                                 case pr := <- md.chnUnsubscribe:
                                     unsubscribedProcs[pr] = struct{}{}
                                     quit = (p == pr)
@@ -86,15 +179,19 @@ func (md *messageDispatcher) goroutine() {
                         }
                     }
                 }
+                if !accepted && deadLetter != nil && msg.Pred.String() != False().String() {
+                    // empty messages filling unused mids are not dead letters
+                    deadLetter(msg)
+                }
                 if md.evtMid == msg.Id {
                     close(md.chnEvtMid)
                 }
                 //fmt.Println("Served",msg.Id)
-                for p := range toSubscribe{
-                    subscribedProcs[p] = struct{}{}
+                for _, p := range toSubscribe{
+                    subscribe(p)
                 }
                 for p := range unsubscribedProcs{
-                    delete(subscribedProcs, p)
+                    unsubscribe(p)
                 }
                 for quit := false; !quit;{
                     select{
@@ -103,19 +200,23 @@ func (md *messageDispatcher) goroutine() {
                         quit = true
                     case prs := <- md.chnSubscribe:
                         for _, pr := range prs{
-                            subscribedProcs[pr] = struct{}{}
+                            subscribe(pr)
                         }
                     case pr := <- md.chnUnsubscribe:
-                        delete(subscribedProcs, pr)
+                        unsubscribe(pr)
+                    case policy = <- md.chnPolicy:
+                    case deadLetter = <- md.chnDeadLetter:
                     }
                 }
                         
             case prs := <- md.chnSubscribe:
                 for _, pr := range prs{
-                    subscribedProcs[pr] = struct{}{}
+                    subscribe(pr)
                 }
             case pr := <- md.chnUnsubscribe:
-                delete(subscribedProcs, pr) 
+                unsubscribe(pr)
+            case policy = <- md.chnPolicy:
+            case deadLetter = <- md.chnDeadLetter:
         }
     }
 }
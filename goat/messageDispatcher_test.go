@@ -0,0 +1,53 @@
+package goat
+
+import (
+    "math/rand"
+    "testing"
+)
+
+func TestOfferOrderRoundRobin(t *testing.T) {
+    procs := []*Process{{}, {}, {}}
+    order := offerOrder(procs, procs[1], DispatchRoundRobin, nil)
+    if order[0] != procs[2] || order[1] != procs[0] || order[2] != procs[1] {
+        t.Fatal("the process after the last accepting one must be offered first")
+    }
+    order = offerOrder(procs, &Process{}, DispatchRoundRobin, nil)
+    if order[0] != procs[0] {
+        t.Fatal("the oldest process must be offered first if the last accepting one left")
+    }
+}
+
+func TestOfferOrderRandom(t *testing.T) {
+    procs := []*Process{{}, {}, {}}
+    rnd := rand.New(rand.NewSource(1))
+    firsts := map[*Process]int{}
+    for i := 0; i < 300; i++ {
+        order := offerOrder(procs, nil, DispatchRandom, rnd)
+        if len(order) != 3 {
+            t.Fatal("every process must be offered the message")
+        }
+        firsts[order[0]]++
+    }
+    for _, p := range procs {
+        if firsts[p] < 50 {
+            t.Fatal("some process is rarely offered messages first", firsts)
+        }
+    }
+}
+
+func TestDeadLetters(t *testing.T) {
+    chnIn := newUnboundChanMessage()
+    chnNext := make(chan struct{})
+    md := newMessageDispatcher(chnIn, make(chan []*Process), make(chan *Process), chnNext, NewAttributes(), nil)
+    got := make(chan Message, 2)
+    md.SetDeadLetterHandler(func(msg Message) {
+        got <- msg
+    })
+    chnIn.In <- Message{Id: 0, Message: NewTuple("lost"), Pred: True()}
+    <-chnNext
+    chnIn.In <- Message{Id: 1, Message: NewTuple(), Pred: False()}
+    <-chnNext
+    if len(got) != 1 || (<-got).Id != 0 {
+        t.Fatal("only the message nobody accepted must be a dead letter")
+    }
+}
@@ -10,6 +10,9 @@ type Message struct {
     Id int
     Message Tuple
     Pred ClosedPredicate
+    // Sender is the id of the sending component, as stated by the
+    // infrastructure, or InfrastructureId; 0 if the agent does not know it.
+    Sender int
 }
 
 func makeMessage(messageToSend messagePredicate, mid int) Message{
@@ -1,13 +1,15 @@
 package goat
 
 //import "fmt"
+import "sort"
 
 type midHandler struct {
     chnFreshMid *unboundChanInt
     chnMsgFromProc chan messagePredicate
     chnRetry chan struct{}
     chnNewStop chan chan struct{}
-    chnNewSend chan chan struct{}
+    chnNewSend chan sendRequest
+    chnDrain chan chan int
     chnTimeToAskMid chan struct{}
     askMidPolicy askMidPol
     agent Agent
@@ -17,6 +19,13 @@ type midHandler struct {
     chnEvtMid chan struct{}
 }
 
+// sendRequest is a process willing to send on incomingMids; requests with
+// higher priority are offered each fresh mid first.
+type sendRequest struct {
+    incomingMids chan struct{}
+    priority int
+}
+
 type askMidPol int
 
 const (
@@ -25,19 +34,23 @@ const (
     ampUnconditional askMidPol = iota
 )
 
-func NewMidHandler(chnFreshMid *unboundChanInt, agent Agent, attributes *Attributes, chnNext chan struct{}) *midHandler{
+func NewMidHandler(chnFreshMid *unboundChanInt, agent Agent, attributes *Attributes, chnNext chan struct{}, onPanic func(error)) *midHandler{
     mh := midHandler{ chnFreshMid: chnFreshMid,
         chnMsgFromProc: make(chan messagePredicate),
         chnRetry: make(chan struct{}),
         chnNewStop: make(chan chan struct{}),
-        chnNewSend: make(chan chan struct{}),
+        chnNewSend: make(chan sendRequest),
+        chnDrain: make(chan chan int),
         chnTimeToAskMid: make(chan struct{}),
         askMidPolicy: ampNone,
         agent: agent,
         attributes: attributes,
         chnNext: chnNext,
         evtMid: -1}
-    go func(){mh.start()}()
+    go func(){
+        defer recoverTo("the mid handler", onPanic)
+        mh.start()
+    }()
     return &mh
 }
 
@@ -53,39 +66,62 @@ func (mh *midHandler) SendMessage(msg messagePredicate, incomingMids chan struct
     mh.chnMsgFromProc <- msg
 }
 func (mh *midHandler) AskMids(incomingMids chan struct{}) {
-    mh.chnNewSend <- incomingMids
+    mh.AskMidsPriority(incomingMids, 0)
+}
+func (mh *midHandler) AskMidsPriority(incomingMids chan struct{}, priority int) {
+    mh.chnNewSend <- sendRequest{incomingMids, priority}
 }
 func (mh *midHandler) RetryLater(incomingMids chan struct{}) {
     mh.chnRetry <- struct{}{}
 }
 
 func (mh *midHandler) start() {
-    sendingChans := map[chan struct{}]struct{}{}
+    sendingChans := map[chan struct{}]int{}
     mh.chnTimeToAskMid = make(chan struct{})
     mh.askMidPolicy = ampNone
+    // when draining no mid is asked nor offered anymore: once the mids asked
+    // so far have been filled, the last one is sent on chnDrained
+    pendingAsks := 0
+    lastMid := -1
+    draining := false
+    var chnDrained chan int
     for{
+        if chnDrained != nil && pendingAsks <= 0 {
+            chnDrained <- lastMid
+            chnDrained = nil
+        }
         select {
             case <- mh.chnTimeToAskMid:
                 dprintln("askmid")
                 mh.chnTimeToAskMid = make(chan struct{})
                 mh.askMidPolicy = ampNone
-                mh.agent.AskMid()
+                if !draining {
+                    pendingAsks++
+                    mh.askMid(sendingChans)
+                }
+                
+            case chnDrained = <- mh.chnDrain:
+                draining = true
+                mh.chnTimeToAskMid = make(chan struct{})
+                mh.askMidPolicy = ampNone
                 
             case mid := <- mh.chnFreshMid.Out:
                 //fmt.Println("Prepare a send", mid)
+                pendingAsks--
+                lastMid = mid
                 stoppedChans := map[chan struct{}]struct{}{}
-                toBeAddedChans := map[chan struct{}]struct{}{}
+                toBeAddedChans := map[chan struct{}]int{}
                 midConsumed := false
                 messageToSend := messagePredicate{invalid: true}
-                for chn := range sendingChans {
-                    if _,has := stoppedChans[chn]; !midConsumed && !has{
+                for _, chn := range byPriority(sendingChans) {
+                    if _,has := stoppedChans[chn]; !draining && !midConsumed && !has{
                         withdraw := false
                         for quit:= false;!quit;{
                             select {
                             case chn <- struct{}{}:
                                 quit = true
                             case csnd := <- mh.chnNewSend:
-                                toBeAddedChans[csnd] = struct{}{}
+                                toBeAddedChans[csnd.incomingMids] = csnd.priority
                             case cstop := <- mh.chnNewStop:
                                 if cstop == chn {
                                     quit = true
@@ -106,12 +142,11 @@ func (mh *midHandler) start() {
                             
                             case messageToSend = <-mh.chnMsgFromProc:
                                 midConsumed = true
-                                mh.attributes.commit()
                                 stoppedChans[chn] = struct{}{}
                                 quit = true
                             
                             case csnd := <- mh.chnNewSend:
-                                toBeAddedChans[csnd] = struct{}{}
+                                toBeAddedChans[csnd.incomingMids] = csnd.priority
                                 
                             case <-mh.chnRetry:
                                 quit = true
@@ -122,16 +157,21 @@ func (mh *midHandler) start() {
                 }
                 
                 mh.agent.SendMessage(makeMessage(messageToSend, mid))
+                if midConsumed {
+                    // the changes staged by the sender become visible only
+                    // now that its message has been emitted
+                    mh.attributes.commit()
+                }
                 if mh.evtMid == mid {
                     close(mh.chnEvtMid)
                 }
                 //fmt.Println("X Serving ->", mid)
                 
                 hasFreshChans := false
-                for chn := range toBeAddedChans {
+                for chn, priority := range toBeAddedChans {
                     if _, has := stoppedChans[chn]; !has {
                         hasFreshChans = true
-                        sendingChans[chn] = struct{}{}
+                        sendingChans[chn] = priority
                     }
                 }
                 for chn := range stoppedChans {
@@ -139,7 +179,9 @@ func (mh *midHandler) start() {
                 }
                 dprintln("Y Serving ->", mid)
                 
-                if hasFreshChans || (midConsumed && len(sendingChans) > 0){
+                if draining {
+                    // no more mids
+                } else if hasFreshChans || (midConsumed && len(sendingChans) > 0){
                     mh.chnTimeToAskMid = make(chan struct{})
                     mh.askMidPolicy = ampUnconditional
                     close(mh.chnTimeToAskMid)
@@ -155,9 +197,9 @@ func (mh *midHandler) start() {
                 delete(sendingChans, cstop)
             
             case csnd := <- mh.chnNewSend:
-                sendingChans[csnd] = struct{}{}
+                sendingChans[csnd.incomingMids] = csnd.priority
                 //if len(sendingChans) == 1 {
-                    if mh.askMidPolicy != ampUnconditional{
+                    if !draining && mh.askMidPolicy != ampUnconditional{
                         mh.chnTimeToAskMid = make(chan struct{})
                         mh.askMidPolicy = ampUnconditional
                         close(mh.chnTimeToAskMid)
@@ -167,3 +209,30 @@ func (mh *midHandler) start() {
     }
 }
 
+
+// askMid asks the agent for a mid, with the highest priority of the waiting
+// sends if the infrastructure orders sends by priority.
+func (mh *midHandler) askMid(sendingChans map[chan struct{}]int) {
+    pa, hasPriorities := mh.agent.(PriorityAgent)
+    if !hasPriorities {
+        mh.agent.AskMid()
+        return
+    }
+    priority := 0
+    for _, chn := range byPriority(sendingChans) {
+        priority = sendingChans[chn]
+        break
+    }
+    pa.AskMidPriority(priority)
+}
+
+func byPriority(sendingChans map[chan struct{}]int) []chan struct{} {
+    chans := make([]chan struct{}, 0, len(sendingChans))
+    for chn := range sendingChans {
+        chans = append(chans, chn)
+    }
+    sort.SliceStable(chans, func(i, j int) bool {
+        return sendingChans[chans[i]] > sendingChans[chans[j]]
+    })
+    return chans
+}
@@ -0,0 +1,106 @@
+package goat
+
+import (
+    "fmt"
+)
+
+/*
+MQTTClient is the subset of an MQTT client used by MQTTBridge. It can be
+implemented on top of any MQTT library.
+*/
+type MQTTClient interface {
+    Publish(topic string, payload []byte) error
+    Subscribe(topic string, handler func(topic string, payload []byte)) error
+}
+
+/*
+MQTTRoute maps a selector to MQTT topics. The attributes of Comp act as the
+selector: every AbC message whose predicate they satisfy is delivered to Comp
+and published on OutTopic as a JSON array, and every payload published on
+InTopic is sent by Comp to the components satisfying InPred. An empty topic
+disables the corresponding direction.
+*/
+type MQTTRoute struct {
+    Comp *Component
+    OutTopic string
+    InTopic string
+    InPred Predicate
+}
+
+/*
+MQTTBridge makes MQTT devices appear as components. Comp, OutTopic, InTopic and
+InPred form the first route (see MQTTRoute), Routes the others, so that
+different selectors are mapped to different topics; a route without Comp is
+ignored. A payload that cannot be decoded (see DecodeTupleJSON) is not sent,
+and the failure is reported on the Errors of the Comp of its route.
+*/
+type MQTTBridge struct {
+    Comp *Component
+    Client MQTTClient
+    OutTopic string
+    InTopic string
+    InPred Predicate
+    Routes []MQTTRoute
+    // OnError, if set, is called when a payload cannot be decoded or published.
+    OnError func(error)
+}
+
+/*
+Start subscribes to the incoming topics and starts republishing the messages
+received by the components of the routes on the outgoing ones.
+*/
+func (mb *MQTTBridge) Start() error {
+    routes := append([]MQTTRoute{{mb.Comp, mb.OutTopic, mb.InTopic, mb.InPred}}, mb.Routes...)
+    for _, route := range routes {
+        if route.Comp == nil {
+            continue
+        }
+        if err := mb.startRoute(route); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (mb *MQTTBridge) startRoute(route MQTTRoute) error {
+    if route.InTopic != "" {
+        err := mb.Client.Subscribe(route.InTopic, func(topic string, payload []byte) {
+            msg, err := DecodeTupleJSON(payload)
+            if err != nil {
+                err = fmt.Errorf("goat: undecodable payload on %s: %w", topic, err)
+                route.Comp.reportError(err)
+                mb.onError(err)
+                return
+            }
+            if err = route.Comp.sendOnce(msg, route.InPred); err != nil {
+                mb.onError(err)
+            }
+        })
+        if err != nil {
+            return err
+        }
+    }
+    if route.OutTopic != "" {
+        route.Comp.Start(func(p *Process) {
+            for {
+                msg := p.Receive(func(*Attributes, Tuple) bool {
+                    return true
+                })
+                payload, err := EncodeTupleJSON(msg)
+                if err == nil {
+                    err = mb.Client.Publish(route.OutTopic, payload)
+                }
+                if err != nil {
+                    mb.onError(err)
+                }
+            }
+        })
+    }
+    return nil
+}
+
+func (mb *MQTTBridge) onError(err error) {
+    if mb.OnError != nil {
+        mb.OnError(err)
+    }
+}
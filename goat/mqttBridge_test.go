@@ -0,0 +1,89 @@
+package goat
+
+import (
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// memoryMQTT is an in-memory MQTTClient: Publish records the payloads and
+// Subscribe keeps the handlers, which the test calls directly.
+type memoryMQTT struct {
+    lock *sync.Mutex
+    handlers map[string]func(topic string, payload []byte)
+    published chan [2]string
+}
+
+func (mm *memoryMQTT) Publish(topic string, payload []byte) error {
+    mm.published <- [2]string{topic, string(payload)}
+    return nil
+}
+
+func (mm *memoryMQTT) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+    mm.lock.Lock()
+    defer mm.lock.Unlock()
+    mm.handlers[topic] = handler
+    return nil
+}
+
+func TestMQTTBridge(t *testing.T) {
+    server := testServer(17721)
+    client := &memoryMQTT{&sync.Mutex{}, map[string]func(string, []byte){}, make(chan [2]string, 10)}
+    errs := make(chan error, 10)
+    mb := &MQTTBridge{
+        Comp: NewComponent(NewSingleServerAgent(server), map[string]interface{}{"kind": "lamp"}),
+        Client: client,
+        OutTopic: "lamps/out",
+        InTopic: "lamps/in",
+        InPred: Equals(Receiver("kind"), "app"),
+        Routes: []MQTTRoute{{
+            Comp: NewComponent(NewSingleServerAgent(server), map[string]interface{}{"kind": "fan"}),
+            OutTopic: "fans/out",
+        }},
+        OnError: func(err error) {
+            errs <- err
+        },
+    }
+    if err := mb.Start(); err != nil {
+        t.Fatal(err)
+    }
+    app := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"kind": "app"})
+    got := make(chan Tuple, 1)
+    app.Start(func(p *Process) {
+        got <- p.Receive(func(*Attributes, Tuple) bool { return true })
+        p.Send(NewTuple("spin", 3), Equals(Receiver("kind"), "fan"))
+    })
+
+    client.handlers["lamps/in"]("lamps/in", []byte(`[{"a":1}]`))
+    select {
+        case <-errs:
+        case <-time.After(5 * time.Second):
+            t.Fatal("invalid payload not reported")
+    }
+    select {
+        case err := <-mb.Comp.Errors():
+            if !strings.Contains(err.Error(), "lamps/in") {
+                t.Error(err)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("invalid payload not reported on the Errors of the component")
+    }
+    client.handlers["lamps/in"]("lamps/in", []byte(`["on",true]`))
+    select {
+        case msg := <-got:
+            if !msg.IsLong(2) || msg.Get(0) != "on" || msg.Get(1) != true {
+                t.Error(msg)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("payload not delivered")
+    }
+    select {
+        case pub := <-client.published:
+            if pub != [2]string{"fans/out", `["spin",3]`} {
+                t.Error(pub)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("message not published")
+    }
+}
@@ -46,7 +46,7 @@ func (nc *netCommunication) sendToServer(tokens... string) {
 func (nc *netCommunication) receiveFromServer() (string, []string) {
     conn, err := nc.listener.Accept()
     _ = err
-    serverMsg, err := bufio.NewReader(conn).ReadString('\n')
+    serverMsg, err := readLine(bufio.NewReader(conn))
     if err == nil {
         escTokens := strings.Split(serverMsg[:len(serverMsg)-1], " ")
         tokens := make([]string, len(escTokens))
@@ -96,7 +96,7 @@ func (nc *netCommunication) doIncomingProcess(chnRegistered chan<- bool) {
                 nc.chnMids <- mid
                 
             case "DATA":
-                pred, _ := ToPredicate(params[2])
+                pred := decodePredicate(params[2], nil)
                 mid := atoi(params[0])
                 cid := atoi(params[1])
                 inMsg := inMessage {
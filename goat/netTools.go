@@ -6,8 +6,30 @@ import (
     "sync"
     "fmt"
     "strings"
+    "errors"
 )
 
+// maxLineLength is the maximum size of a protocol line, newline included, read
+// from a connection; the connection is dropped if it is exceeded.
+const maxLineLength = 16 << 20
+
+var errLineTooLong = errors.New("protocol line too long")
+
+// readLine reads a line, as ReadString('\n'), of at most maxLineLength bytes.
+func readLine(reader *bufio.Reader) (string, error) {
+    var line []byte
+    for {
+        chunk, err := reader.ReadSlice('\n')
+        if len(line) + len(chunk) > maxLineLength {
+            return "", errLineTooLong
+        }
+        line = append(line, chunk...)
+        if err != bufio.ErrBufferFull {
+            return string(line), err
+        }
+    }
+}
+
 type duplexConn struct {
     conn net.Conn
     reader *bufio.Reader
@@ -34,7 +56,7 @@ func (dc *duplexConn) RemoteAddr() net.Addr{
 }
 
 func (dc *duplexConn) ReceiveErr() (string, []string, error) {
-    serverMsg, err := dc.reader.ReadString('\n')
+    serverMsg, err := readLine(dc.reader)
     if err == nil {
         escTokens := strings.Split(serverMsg[:len(serverMsg)-1], " ")
         tokens := make([]string, len(escTokens))
@@ -98,6 +120,25 @@ func listenerInt(port int) (*unboundChanConn, chan struct{}, int){
     return uc, chnReady, <-chnPort
 }
 
+// listenerAt listens on address and returns the connections accepted, and the
+// address it listens on.
+func listenerAt(address string) (*unboundChanConn, net.Addr, error) {
+    listener, err := net.Listen("tcp", address)
+    if err != nil {
+        return nil, nil, err
+    }
+    uc := newUnboundChanConn()
+    go func(){
+        for{
+            conn, err := listener.Accept()
+            if err == nil {
+                uc.In <- newDuplexConn(conn)
+            }
+        }
+    }()
+    return uc, listener.Addr(), nil
+}
+
 func listener(port int) (*unboundChanConn, chan struct{}) {
     ucc, rd, _ := listenerInt(port)
     return ucc, rd
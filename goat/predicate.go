@@ -2,6 +2,7 @@ package goat
 
 import (
     "fmt"
+    "time"
 )
 
 /*
@@ -96,6 +97,9 @@ func (eq ccomp) Satisfy(attr *Attributes) bool {
 func (eq ccomp) String() string {
     return fmt.Sprintf("%s(%s,%s)", GetOpLetter(eq.Op), escapeWithType(eq.Par1, eq.IsAttr1), escapeWithType(eq.Par2, eq.IsAttr2))
 }
+func (eq ccomp) CloseUnder(attr *Attributes) ClosedPredicate {
+    return eq
+}
 
 type compattr struct {
     name string
@@ -119,6 +123,17 @@ func Evaluate(fnc func(...interface{})interface{}, params ...interface{}) evalat
     return evalattr{fnc, params}
 }
 
+/*
+Clock evaluates to the current time, in milliseconds since the Unix epoch (see
+SetTimeSource). As any other argument, it is evaluated when the predicate is
+closed, e.g. LessThan(Clock(), Comp("deadline")) holds until the deadline.
+*/
+func Clock() evalattr{
+    return Evaluate(func(...interface{}) interface{} {
+        return int(timeNow().UnixNano() / int64(time.Millisecond))
+    })
+}
+
 type comp struct {
     arg1 interface{}
     op string
@@ -290,6 +305,9 @@ func (iin cisin) Satisfy(attr *Attributes) bool {
 func (eq cisin) String() string {
     return fmt.Sprintf("C(%s,%s)", escapeWithType(eq.Par1, eq.IsAttr1), escapeWithType(eq.Par2, eq.IsAttr2))
 }
+func (eq cisin) CloseUnder(attr *Attributes) ClosedPredicate {
+    return eq
+}
 
 /*
 And represents a predicate that is true iff both the predicates P1 and P2 are true.
@@ -315,6 +333,9 @@ func (a cand) Satisfy(attr *Attributes) bool {
 func (a cand) String() string {
     return fmt.Sprintf("&(%s,%s)", a.p1, a.p2)
 }
+func (a cand) CloseUnder(attr *Attributes) ClosedPredicate {
+    return a
+}
 
 type and struct {
     p1 Predicate
@@ -357,6 +378,9 @@ func (o cor) Satisfy(attr *Attributes) bool {
 func (o cor) String() string {
     return fmt.Sprintf("|(%s,%s)", o.p1, o.p2)
 }
+func (o cor) CloseUnder(attr *Attributes) ClosedPredicate {
+    return o
+}
 
 type or struct {
     p1 Predicate
@@ -393,6 +417,9 @@ func (n cnot) Satisfy(attr *Attributes) bool {
 func (n cnot) String() string {
     return fmt.Sprintf("!(%s)", n.p)
 }
+func (n cnot) CloseUnder(attr *Attributes) ClosedPredicate {
+    return n
+}
 
 type not struct {
     p Predicate
@@ -449,13 +476,31 @@ func (f _false) CloseUnder(attr *Attributes) ClosedPredicate {
 
 /////////////
 
-func ToPredicate(s string) (ClosedPredicate, error){
-    p, _, err := toPredicateInt(s, 0)
-    return p, err
+/*
+ToPredicate decodes a predicate encoded by ClosedPredicate.String. Closed predicates
+are Predicates too (closing them has no effect), so the result can be sent as is.
+*/
+func ToPredicate(s string) (pred ClosedPredicate, err error){
+    defer func() {
+        if r := recover(); r != nil {
+            pred, err = nil, invalidPredicate(s)
+        }
+    }()
+    p, next, err := toPredicateInt(s, 0)
+    if err == nil && next != len(s) {
+        err = invalidPredicate(s)
+    }
+    if err != nil {
+        return nil, err
+    }
+    return p, nil
 }
 
 func toPredicateInt(s string, from int) (ClosedPredicate, int, error) {
     escapedS := (s)
+    if from+2 > len(s) {
+        return nil, from, invalidPredicate(s)
+    }
     switch s[from: from+2] {
         case "C(":
             attr1, is1Attr, commaPos := unescapeWithType(escapedS, from+2)
@@ -465,23 +510,35 @@ func toPredicateInt(s string, from int) (ClosedPredicate, int, error) {
             attr1, is1Attr, commaPos := unescapeWithType(escapedS, from+2)
             attr2, is2Attr, bracketPos := unescapeWithType(escapedS, commaPos+1)
             return ccomp{attr1, is1Attr, GetLetterOp(s[from:from+1]), attr2, is2Attr}, bracketPos+1, nil
-        case "&(":
-            p1, commaPos, _ := toPredicateInt(s, from+2)
-            p2, bracketPos, _ := toPredicateInt(s, commaPos+1)
-            return cand{p1, p2}, bracketPos+1, nil
-        case "|(":
-            p1, commaPos, _ := toPredicateInt(s, from+2)
-            p2, bracketPos, _ := toPredicateInt(s, commaPos+1)
+        case "D(":
+            x, isXAttr, commaPos1 := unescapeWithType(escapedS, from+2)
+            y, isYAttr, commaPos2 := unescapeWithType(escapedS, commaPos1+1)
+            r, isRAttr, bracketPos := unescapeWithType(escapedS, commaPos2+1)
+            return cdist{x, isXAttr, y, isYAttr, r, isRAttr}, bracketPos+1, nil
+        case "&(", "|(":
+            p1, commaPos, err := toPredicateInt(s, from+2)
+            if err != nil || commaPos >= len(s) || s[commaPos] != ',' {
+                return nil, commaPos, invalidPredicate(s)
+            }
+            p2, bracketPos, err := toPredicateInt(s, commaPos+1)
+            if err != nil || bracketPos >= len(s) || s[bracketPos] != ')' {
+                return nil, bracketPos, invalidPredicate(s)
+            }
+            if s[from] == '&' {
+                return cand{p1, p2}, bracketPos+1, nil
+            }
             return cor{p1, p2}, bracketPos+1, nil
         case "!(":
-            p, bracketPos, _ := toPredicateInt(s, from+2)
+            p, bracketPos, err := toPredicateInt(s, from+2)
+            if err != nil || bracketPos >= len(s) || s[bracketPos] != ')' {
+                return nil, bracketPos, invalidPredicate(s)
+            }
             return cnot{p}, bracketPos+1, nil
         case "TT":
             return _true{}, from+2, nil
         case "FF":
             return _false{}, from+2, nil
         default:
-            //TODO Error!
-            return nil, from + 1, nil
+            return nil, from, invalidPredicate(s)
     }
 }
@@ -1,6 +1,9 @@
 package goat
 
 import (
+	"fmt"
+	"runtime"
+	"sync"
 	"time"
 )
 
@@ -12,6 +15,10 @@ type Process struct {
 
 	//chnAcceptMessage chan bool
 	chnMessage       chan Message
+	priority         int
+	tokenTaken       bool
+	chnKilled        chan struct{}
+	killOnce         *sync.Once
 	
 	DBGSstatus int
 }
@@ -26,10 +33,32 @@ func NewProcess(c *Component) *Process {
 
 		//chnAcceptMessage: make(chan bool),
 		chnMessage:       make(chan Message),
+		chnKilled:        make(chan struct{}),
+		killOnce:         &sync.Once{},
 	}
 	return &p
 }
 
+// kill makes p terminate the next time it waits for a message, a message id or
+// the end of a sleep.
+func (p *Process) kill() {
+	p.killOnce.Do(func() {
+		close(p.chnKilled)
+	})
+}
+
+/*
+SetPriority sets the priority of the sends of p (0 by default). When several
+processes of the same component want to send, the ones with higher priority are
+given the chance first. Priorities are compared across components only if the
+infrastructure supports it, e.g. a CentralServer with priority sequencing (see
+CentralServer.SetPrioritySequencing); otherwise the order of the messages of
+different components is fixed by the infrastructure regardless of priorities.
+*/
+func (p *Process) SetPriority(priority int) {
+	p.priority = priority
+}
+
 func (p *Process) unsubscribe() {
 	//close(p.chnAcceptMessage)
 	dprintln("Unsubscribing")
@@ -56,8 +85,9 @@ func (p *Process) Run(procFncs ...func(p *Process)) {
 	for i, pr := range procs{
 	    go func(q *Process, procFnc func(p *Process), i int){
 	        //fmt.Println(i)
+		    defer q.unsubscribe()
+		    defer recoverTo("a process", q.Comp.reportError)
 	        q.Call(procFnc)
-		    q.unsubscribe()
 	    }(pr, procFncs[i], i)
 	}
 	/*go func() {
@@ -88,8 +118,9 @@ func (p *Process) Spawn(procFncs ...func(p *Process)) {
 	p.Comp.chnSubscribe <- procs
 	for i, pr := range procs{
 	    go func(q *Process, procFnc func(p *Process)){
+		    defer q.unsubscribe()
+		    defer recoverTo("a process", q.Comp.reportError)
 	        q.Call(procFnc)
-		    q.unsubscribe()
 	    }(pr, procFncs[i])
 	}
     /*
@@ -105,6 +136,34 @@ func (p *Process) Spawn(procFncs ...func(p *Process)) {
 	<-chnSubscribed*/
 }
 
+/*
+SpawnComponent creates a new component on the same infrastructure of p, with
+the environment initialized according to attrInit, and starts it as procFncs.
+Any message received while the new component registers is rejected.
+*/
+func (p *Process) SpawnComponent(attrInit map[string]interface{}, procFncs ...func(p *Process)) (*Component, error) {
+	type spawned struct {
+		c   *Component
+		err error
+	}
+	chnSpawned := make(chan spawned)
+	go func() {
+		c, err := p.Comp.NewSibling(attrInit)
+		if err == nil {
+			c.Start(procFncs...)
+		}
+		chnSpawned <- spawned{c, err}
+	}()
+	for {
+		select {
+		case <-p.chnMessage:
+			p.Comp.messageDispatcher.chnAcceptMessage <- false
+		case s := <-chnSpawned:
+			return s.c, s.err
+		}
+	}
+}
+
 type attributesInMessage struct {
 	attribs *Attributes
 	inMsg   Message
@@ -140,6 +199,7 @@ type SendReceive struct {
 	msgPred ClosedPredicate
 	valid   bool
 	accept  func(*Attributes, Tuple) bool
+	acceptMsg func(*Attributes, Message) bool
 	updFnc  func(*Attributes)
 }
 
@@ -173,6 +233,31 @@ func SendUpdate(msg Tuple, msgPred ClosedPredicate, updFnc func(*Attributes)) Se
 	return ThenSendUpdate(msg, msgPred, updFnc)
 }
 
+/*
+ThenSet signals the intention to change the environment according to updFnc,
+without exchanging messages. In a Select, the predicates are evaluated again
+whenever the attributes change, hence a case with ThenSet reacts to the
+attribute changes that make its predicate true.
+*/
+func ThenSet(updFnc func(*Attributes)) SendReceive {
+	return ThenSendUpdate(NewTuple(), False().CloseUnder(nil), updFnc)
+}
+
+func Set(updFnc func(*Attributes)) SendReceive {
+	return ThenSet(updFnc)
+}
+
+/*
+ThenReact is like ThenSet, but leaves the environment unchanged.
+*/
+func ThenReact() SendReceive {
+	return ThenSet(func(*Attributes){})
+}
+
+func React() SendReceive {
+	return ThenReact()
+}
+
 /*
 ThenFail signals the intention to retry the Send/Receive when a new message arrives
 or the attributes of the component change.
@@ -199,44 +284,103 @@ func Receive(accept func(*Attributes, Tuple) bool) SendReceive {
 	return ThenReceive(accept)
 }
 
+// thenReceiveMessage is like ThenReceive, but accept is also given the id and
+// the predicate of the message.
+func thenReceiveMessage(accept func(*Attributes, Message) bool) SendReceive {
+	return SendReceive{
+		action:    receiveAction,
+		acceptMsg: accept,
+	}
+}
+
+func (sr SendReceive) acceptMessage(attr *Attributes, msg Message) bool {
+	if sr.acceptMsg != nil {
+		return sr.acceptMsg(attr, msg)
+	}
+	return sr.accept(attr, msg.Message)
+}
+
 /*
 Sleep pauses the process p for msec milliseconds. Any message received during
 this timeframe is rejected.
 */
 func (p *Process) Sleep(msec int) {
-	timeout := time.After(time.Duration(msec) * time.Millisecond)
+	p.sleepFor(time.Duration(msec) * time.Millisecond)
+}
+
+func (p *Process) sleepFor(d time.Duration) {
+	timeout := time.After(d)
 	for {
 		select {
 		case <-p.chnMessage:
 			p.Comp.messageDispatcher.chnAcceptMessage <- false
 		case <-timeout:
 			return
+		case <-p.chnKilled:
+			runtime.Goexit()
 		}
 	}
 }
 
 func (p *Process) sendrec(chooseFnc func(attr *Attributes, receiving bool) SendReceive, onlyReceive bool) Tuple {
+    msg, _ := p.sendrecTimeout(chooseFnc, onlyReceive, nil)
+    return msg
+}
+
+/*
+sendrecTimeout behaves like sendrec, but gives up when chnTimeout fires (a nil
+channel never fires). It returns false iff it gave up.
+*/
+func (p *Process) sendrecTimeout(chooseFnc func(attr *Attributes, receiving bool) SendReceive, onlyReceive bool, chnTimeout <-chan time.Time) (Tuple, bool) {
     incomingMids := make(chan struct{})
+    // with a send rate set, mids are asked only once the send is allowed;
+    // meanwhile incoming messages are still served. The send reserved is
+    // given back unless a message is actually sent.
+    asked := false
+    sent := false
+    var chnSendAllowed <-chan time.Time
     if !onlyReceive {
-        p.Comp.midHandler.AskMids(incomingMids)
+        wait, reserved := p.reserveSend()
+        if reserved {
+            defer func() {
+                if !sent {
+                    p.unreserveSend()
+                }
+            }()
+        }
+        if wait > 0 {
+            chnSendAllowed = time.After(wait)
+        } else {
+            p.Comp.midHandler.AskMidsPriority(incomingMids, p.priority)
+            asked = true
+        }
     }
     for {
         select {
+        case <- chnSendAllowed:
+            chnSendAllowed = nil
+            p.Comp.midHandler.AskMidsPriority(incomingMids, p.priority)
+            asked = true
         case inMsg := <-p.chnMessage:
             attrs := p.Comp.attributes
-			nextAction := chooseFnc(attrs, true)
-			if nextAction.action == receiveAction &&
-				attrs.Satisfy(inMsg.Pred) &&
-				nextAction.accept(attrs, inMsg.Message) {
+            // a panicking guard or accept function rejects the message
+            accepted := false
+            catchPanic("a receive", p.Comp.reportError, func() {
+			    nextAction := chooseFnc(attrs, true)
+			    accepted = nextAction.action == receiveAction &&
+				    attrs.Satisfy(inMsg.Pred) &&
+				    nextAction.acceptMessage(attrs, inMsg)
+			})
+			if accepted {
 	            p.DBGSstatus = 2
 	            p.Comp.attributes.commit()
 	            //fmt.Println("used", p.Comp.attributes.GetValue("used"))
 				p.Comp.messageDispatcher.chnAcceptMessage <- true
-				if !onlyReceive {
+				if asked {
 				    p.Comp.midHandler.StopMids(incomingMids)
 				}
 	            p.DBGSstatus = 0
-				return inMsg.Message
+				return inMsg.Message, true
 			} else {
 	            p.DBGSstatus = 3
 	            p.Comp.attributes.rollback()
@@ -245,20 +389,45 @@ func (p *Process) sendrec(chooseFnc func(attr *Attributes, receiving bool) SendR
 			}
 		case <- incomingMids: 
 		    //attrs := p.Comp.attributes
-		    nextAction := chooseFnc(p.Comp.attributes, false)
+		    // a panicking guard or update function gives up the mid
+		    var nextAction SendReceive
+		    if !catchPanic("a send guard", p.Comp.reportError, func() {
+		        nextAction = chooseFnc(p.Comp.attributes, false)
+		    }) {
+		        nextAction = ThenFail()
+		    }
 			if nextAction.action == sendAction {
 				msg := nextAction.msg
 				msgPred := nextAction.msgPred
 				valid := nextAction.valid
-				if valid {
+				if valid && p.Comp.maxEnvelopeSize > 0 && len(msg) > p.Comp.maxEnvelopeSize {
+				    p.Comp.reportError(fmt.Errorf("%w: %d bytes", ErrEnvelopeTooLarge, len(msg)))
+				    p.Comp.attributes.rollback()
+				    p.Comp.midHandler.SendMessage(messagePredicate{invalid: true}, incomingMids)
+				    return NewTuple(), false
+				}
+				if valid && catchPanic("a send update", p.Comp.reportError, func() {
 				    nextAction.updFnc(p.Comp.attributes)
-				    p.Comp.attributes.commit()
+				}) {
+				    // the midHandler commits the update once the message is emitted
 				    p.Comp.midHandler.SendMessage(messagePredicate{msg, msgPred, false}, incomingMids)
-		            return NewTuple()
+				    sent = true
+		            return NewTuple(), true
 				}
 			}
 			p.Comp.attributes.rollback()
 			p.Comp.midHandler.RetryLater(incomingMids)
+		case <- chnTimeout:
+		    if asked {
+		        p.Comp.midHandler.StopMids(incomingMids)
+		    }
+		    return NewTuple(), false
+		case <- p.chnKilled:
+		    if asked {
+		        p.Comp.midHandler.StopMids(incomingMids)
+		    }
+		    // the deferred unsubscribe of Run still runs
+		    runtime.Goexit()
         }
     }
 }
@@ -286,7 +455,7 @@ func (p *Process) sendrecOld(chooseFnc func(attr *Attributes, receiving bool) Se
 				p.chnAcceptMessage <- true
 				close(chnFailTheSend)
 	            p.DBGSstatus = 0
-				return inMsg.Message
+				return inMsg.Message, true
 			} else {
 	            p.DBGSstatus = 3
 				p.chnAcceptMessage <- false
@@ -443,6 +612,79 @@ func (p *Process) GSendUpd(cond Predicate, msg Tuple, pr Predicate, upd func(*At
 	}, false)
 }
 
+func msecTimeout(msec int) <-chan time.Time {
+    return time.After(time.Duration(msec) * time.Millisecond)
+}
+
+/*
+ReceiveWithin is like Receive, but it gives up if no acceptable message is
+received within msec milliseconds. It returns the message and true, or an empty
+tuple and false if the deadline expired.
+*/
+func (p *Process) ReceiveWithin(msec int, accept func(attr *Attributes, msg Tuple) bool) (Tuple, bool) {
+	return p.sendrecTimeout(
+		func(attr *Attributes, receiving bool) SendReceive {
+			if receiving {
+				return ThenReceive(accept)
+			} else {
+				return ThenFail()
+			}
+		}, true, msecTimeout(msec))
+}
+
+/*
+SendWithin is like Send, but the message expires if it cannot be sent within
+msec milliseconds. It returns false iff the message was not sent.
+*/
+func (p *Process) SendWithin(msec int, msg Tuple, pr Predicate) bool {
+    return p.GSendUpdWithin(msec, True(), msg, pr, func(*Attributes){})
+}
+
+/*
+GSendUpdWithin is like GSendUpd, but the message expires if cond is not
+satisfied within msec milliseconds. It returns false iff the message was not
+sent; in that case upd is not applied.
+*/
+func (p *Process) GSendUpdWithin(msec int, cond Predicate, msg Tuple, pr Predicate, upd func(*Attributes)) bool {
+    _, sent := p.sendrecTimeout(func(attr *Attributes, receiving bool) SendReceive {
+		if receiving || !cond.CloseUnder(attr).Satisfy(attr) {
+			return ThenFail()
+		} else {
+		    cmsg := msg.CloseUnder(attr)
+		    cpr := pr.CloseUnder(attr)
+		    return ThenSendUpdate(cmsg, cpr, upd)
+		}
+	}, false, msecTimeout(msec))
+	return sent
+}
+
+/*
+ScatterGather sends msg to the components satisfying pr, then collects the
+replies satisfying correlation until k of them are received (k <= 0 means no
+limit) or msec milliseconds have passed since the message was sent. It returns
+the replies in the order they were received.
+*/
+func (p *Process) ScatterGather(msg Tuple, pr Predicate, correlation func(attr *Attributes, reply Tuple) bool, k int, msec int) []Tuple {
+    p.Send(msg, pr)
+    chnTimeout := msecTimeout(msec)
+    replies := []Tuple{}
+    for k <= 0 || len(replies) < k {
+        reply, received := p.sendrecTimeout(
+            func(attr *Attributes, receiving bool) SendReceive {
+                if receiving {
+                    return ThenReceive(correlation)
+                } else {
+                    return ThenFail()
+                }
+            }, true, chnTimeout)
+        if !received {
+            break
+        }
+        replies = append(replies, reply)
+    }
+    return replies
+}
+
 type selectcase struct{
     pred Predicate
     action SendReceive
@@ -469,26 +711,53 @@ func Case(pred Predicate, action SendReceive, then func(*Process)) selectcase{
 /*
 Select is a statement that allows the process to evolve differently according to
 the environment. Each possible evolution is stated in a case. Cases are considered
-sequentially in the order they are given. If none of the case is satisfied, the Select
+sequentially in the order they are given: receive cases when a message arrives,
+the others when the component can send. If none of the case is satisfied, the Select
 statement is repeated as soon as the environment changes.
 */
 func (p *Process) Select(cases ...selectcase){
+    p.selectTimeout(nil, cases)
+}
+
+/*
+SelectWithin is like Select, but it gives up if no case can be taken within msec
+milliseconds. It returns false iff the deadline expired; then the process
+continues as timeout.
+*/
+func (p *Process) SelectWithin(msec int, timeout func(*Process), cases ...selectcase) bool {
+    if !p.selectTimeout(msecTimeout(msec), cases) {
+        p.Call(timeout)
+        return false
+    }
+    return true
+}
+
+func (p *Process) selectTimeout(chnTimeout <-chan time.Time, cases []selectcase) bool {
     var caseN int
-    p.sendrec(func(attr *Attributes, receiving bool) SendReceive {
+    // without send cases no mid is needed
+    onlyReceive := true
+    for _, casei := range cases {
+        if casei.action.action == sendAction {
+            onlyReceive = false
+        }
+    }
+    _, done := p.sendrecTimeout(func(attr *Attributes, receiving bool) SendReceive {
         for i, casei := range cases{
+            if casei.action.action == receiveAction && !receiving ||
+                casei.action.action == sendAction && receiving {
+                continue
+            }
             if casei.pred.CloseUnder(attr).Satisfy(attr){
-                wantsToReceive := casei.action.action == sendAction
-		        if receiving != wantsToReceive {
-			        return ThenFail()
-		        } else {
-		            caseN = i
-		            return casei.action
-		        }
+	            caseN = i
+	            return casei.action
 		    }
 	    }
 	    return ThenFail()
-	}, false)
-	p.Call(cases[caseN].then)
+	}, onlyReceive, chnTimeout)
+	if done {
+	    p.Call(cases[caseN].then)
+	}
+	return done
 }
 
 /*
@@ -0,0 +1,234 @@
+package goat
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+/*
+PromelaModel collects the components of a system and the predicates they use,
+and renders them as a Promela (SPIN) model skeleton. Every attribute becomes a
+global array indexed by component, every string value becomes an mtype symbol
+and every predicate becomes a macro parametrized by the receiver index.
+Process behaviours are Go functions and cannot be inspected, hence each
+component gets an empty proctype that must be completed by hand.
+*/
+type PromelaModel struct {
+    components []promelaComponent
+    predicates []promelaPredicate
+    symbols map[string]string
+    symbolOrder []string
+}
+
+type promelaComponent struct {
+    name string
+    attrs map[string]interface{}
+}
+
+type promelaPredicate struct {
+    name string
+    pred ClosedPredicate
+}
+
+func NewPromelaModel() *PromelaModel {
+    return &PromelaModel{symbols: map[string]string{}}
+}
+
+/*
+AddComponent adds a component with the given name and initial attributes.
+*/
+func (pm *PromelaModel) AddComponent(name string, attrInit map[string]interface{}) {
+    attrs := map[string]interface{}{}
+    for k, v := range attrInit {
+        attrs[k] = v
+    }
+    pm.components = append(pm.components, promelaComponent{name, attrs})
+}
+
+/*
+AddPredicate adds a predicate, exported as the macro name(r) where r is the
+index of the receiver component.
+*/
+func (pm *PromelaModel) AddPredicate(name string, pred ClosedPredicate) {
+    pm.predicates = append(pm.predicates, promelaPredicate{name, pred})
+}
+
+func (pm *PromelaModel) symbol(s string) string {
+    if sym, has := pm.symbols[s]; has {
+        return sym
+    }
+    sym := fmt.Sprintf("s%d", len(pm.symbolOrder))
+    pm.symbols[s] = sym
+    pm.symbolOrder = append(pm.symbolOrder, s)
+    return sym
+}
+
+func (pm *PromelaModel) value(x interface{}) (string, string, error) {
+    switch val := x.(type) {
+        case int:
+            return itoa(val), "int", nil
+        case bool:
+            if val {
+                return "true", "bool", nil
+            } else {
+                return "false", "bool", nil
+            }
+        case string:
+            return pm.symbol(val), "mtype", nil
+        default:
+            return "", "", fmt.Errorf("%v: value not supported by Promela", x)
+    }
+}
+
+func (pm *PromelaModel) operand(x interface{}, isAttr bool) (string, error) {
+    if isAttr {
+        return promelaName(x.(string)) + "[r]", nil
+    }
+    val, _, err := pm.value(x)
+    return val, err
+}
+
+/*
+Predicate translates pred into a Promela expression over the receiver index r.
+*/
+func (pm *PromelaModel) Predicate(pred ClosedPredicate) (string, error) {
+    switch p := pred.(type) {
+        case ccomp:
+            op1, err := pm.operand(p.Par1, p.IsAttr1)
+            if err != nil {
+                return "", err
+            }
+            op2, err := pm.operand(p.Par2, p.IsAttr2)
+            if err != nil {
+                return "", err
+            }
+            return fmt.Sprintf("(%s %s %s)", op1, p.Op, op2), nil
+        case cdist:
+            x, err := pm.operand(p.X, p.IsAttrX)
+            if err != nil {
+                return "", err
+            }
+            y, err := pm.operand(p.Y, p.IsAttrY)
+            if err != nil {
+                return "", err
+            }
+            r, err := pm.operand(p.R, p.IsAttrR)
+            if err != nil {
+                return "", err
+            }
+            lx, ly := promelaName(LocationX) + "[r]", promelaName(LocationY) + "[r]"
+            return fmt.Sprintf("((%s - %s)*(%s - %s) + (%s - %s)*(%s - %s) <= %s*%s)", lx, x, lx, x, ly, y, ly, y, r, r), nil
+        case cand:
+            p1, err := pm.Predicate(p.p1)
+            if err != nil {
+                return "", err
+            }
+            p2, err := pm.Predicate(p.p2)
+            if err != nil {
+                return "", err
+            }
+            return fmt.Sprintf("(%s && %s)", p1, p2), nil
+        case cor:
+            p1, err := pm.Predicate(p.p1)
+            if err != nil {
+                return "", err
+            }
+            p2, err := pm.Predicate(p.p2)
+            if err != nil {
+                return "", err
+            }
+            return fmt.Sprintf("(%s || %s)", p1, p2), nil
+        case cnot:
+            p1, err := pm.Predicate(p.p)
+            if err != nil {
+                return "", err
+            }
+            return fmt.Sprintf("(!%s)", p1), nil
+        case _true:
+            return "true", nil
+        case _false:
+            return "false", nil
+        default:
+            return "", fmt.Errorf("%s: predicate not supported by Promela", pred)
+    }
+}
+
+func promelaName(name string) string {
+    return strings.Map(func(r rune) rune {
+        if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+            return r
+        }
+        return '_'
+    }, name)
+}
+
+/*
+Promela renders the model. It returns an error if an attribute has values of
+different types in different components, or if a value or predicate cannot
+be expressed in Promela.
+*/
+func (pm *PromelaModel) Promela() (string, error) {
+    attrTypes := map[string]string{}
+    attrNames := []string{}
+    inits := []string{}
+    for i, comp := range pm.components {
+        keys := make([]string, 0, len(comp.attrs))
+        for k := range comp.attrs {
+            keys = append(keys, k)
+        }
+        sort.Strings(keys)
+        for _, k := range keys {
+            val, typ, err := pm.value(comp.attrs[k])
+            if err != nil {
+                return "", fmt.Errorf("attribute %s of %s: %v", k, comp.name, err)
+            }
+            if prev, has := attrTypes[k]; !has {
+                attrTypes[k] = typ
+                attrNames = append(attrNames, k)
+            } else if prev != typ {
+                return "", fmt.Errorf("attribute %s is both %s and %s", k, prev, typ)
+            }
+            inits = append(inits, fmt.Sprintf("    %s[%d] = %s;", promelaName(k), i, val))
+        }
+    }
+    macros := []string{}
+    for _, pr := range pm.predicates {
+        expr, err := pm.Predicate(pr.pred)
+        if err != nil {
+            return "", err
+        }
+        macros = append(macros, fmt.Sprintf("#define %s(r) %s", promelaName(pr.name), expr))
+    }
+
+    var out strings.Builder
+    fmt.Fprintf(&out, "#define N %d\n\n", len(pm.components))
+    if len(pm.symbolOrder) > 0 {
+        syms := make([]string, len(pm.symbolOrder))
+        for i, s := range pm.symbolOrder {
+            syms[i] = pm.symbols[s]
+            fmt.Fprintf(&out, "/* %s = %q */\n", pm.symbols[s], s)
+        }
+        fmt.Fprintf(&out, "mtype = { %s };\n\n", strings.Join(syms, ", "))
+    }
+    for _, k := range attrNames {
+        fmt.Fprintf(&out, "%s %s[N];\n", attrTypes[k], promelaName(k))
+    }
+    out.WriteString("\n")
+    for _, m := range macros {
+        out.WriteString(m + "\n")
+    }
+    out.WriteString("\n")
+    for i, comp := range pm.components {
+        fmt.Fprintf(&out, "proctype %s() {\n    int self = %d;\n    skip /* behaviour of %s */\n}\n\n", promelaName(comp.name), i, comp.name)
+    }
+    out.WriteString("init {\n    atomic {\n")
+    for _, in := range inits {
+        out.WriteString("    " + in + "\n")
+    }
+    for _, comp := range pm.components {
+        fmt.Fprintf(&out, "        run %s();\n", promelaName(comp.name))
+    }
+    out.WriteString("    }\n}\n")
+    return out.String(), nil
+}
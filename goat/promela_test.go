@@ -0,0 +1,40 @@
+package goat
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestPromelaPredicate(t *testing.T) {
+    pm := NewPromelaModel()
+    pred := And(Equals(Receiver("role"), "leader"), Not(GreaterThan(Receiver("load"), 3))).CloseUnder(getPrebuiltAttrs())
+    expr, err := pm.Predicate(pred)
+    if err != nil || expr != "((role[r] == s0) && (!(load[r] > 3)))" {
+        t.Error(expr, err)
+    }
+    if _, err := pm.Predicate(Belong("x", Receiver("set")).CloseUnder(getPrebuiltAttrs())); err == nil {
+        t.Error("IsIn cannot be exported")
+    }
+}
+
+func TestPromelaModel(t *testing.T) {
+    pm := NewPromelaModel()
+    pm.AddComponent("leader", map[string]interface{}{"role": "leader", "load": 0})
+    pm.AddComponent("worker", map[string]interface{}{"role": "worker", "load": 2})
+    pm.AddPredicate("toWorkers", Equals(Receiver("role"), "worker").CloseUnder(getPrebuiltAttrs()))
+    model, err := pm.Promela()
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, exp := range []string{"mtype = { s0, s1 };", "int load[N];", "mtype role[N];",
+            "#define toWorkers(r) (role[r] == s1)", "role[1] = s1;", "run worker();"} {
+        if !strings.Contains(model, exp) {
+            t.Error("missing", exp, "in", model)
+        }
+    }
+
+    pm.AddComponent("broken", map[string]interface{}{"load": "high"})
+    if _, err := pm.Promela(); err == nil {
+        t.Error("load is both int and mtype")
+    }
+}
@@ -0,0 +1,850 @@
+package goat
+
+import (
+    "encoding/base64"
+    "encoding/binary"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// protoField is a field of proto/goat.proto: its number and its type (a
+// scalar type, an enum or a message).
+type protoField struct {
+    num int
+    typ string
+    repeated bool
+}
+
+// protoSchema are the messages of proto/goat.proto used by EncodeFrame and
+// DecodeFrame; TestProtoSchema checks that it matches the .proto file.
+var protoSchema = map[string]map[string]protoField{
+    "Frame": {
+        "register": {1, "Register", false},
+        "registered": {2, "Registered", false},
+        "rejected": {3, "Rejected", false},
+        "fenced": {4, "Fenced", false},
+        "id_request": {5, "IdRequest", false},
+        "id_reply": {6, "IdReply", false},
+        "data": {7, "Data", false},
+        "members": {8, "Members", false},
+        "attrs": {9, "Attrs", false},
+        "count": {10, "Count", false},
+        "counted": {11, "Counted", false},
+        "time": {12, "Time", false},
+        "time_is": {13, "TimeIs", false},
+        "beat": {14, "Beat", false},
+        "status_request": {15, "StatusRequest", false},
+        "status": {16, "Status", false},
+        "component_status": {17, "ComponentStatus", false},
+        "status_end": {18, "StatusEnd", false},
+    },
+    "Register": {
+        "port": {1, "int32", false},
+        "identity": {2, "string", false},
+        "namespace": {3, "string", false},
+    },
+    "Registered": {
+        "component_id": {1, "int32", false},
+        "first_message_id": {2, "int32", false},
+    },
+    "Rejected": {
+        "identity": {1, "string", false},
+    },
+    "Fenced": {
+        "identity": {1, "string", false},
+    },
+    "IdRequest": {
+        "component_id": {1, "int32", false},
+        "path": {2, "string", true},
+        "priority": {3, "int32", false},
+    },
+    "IdReply": {
+        "message_id": {1, "int32", false},
+        "path": {2, "string", true},
+    },
+    "Data": {
+        "message_id": {1, "int32", false},
+        "component_id": {2, "int32", false},
+        "predicate": {3, "Predicate", false},
+        "message": {4, "bytes", false},
+    },
+    "Members": {
+        "component_id": {1, "int32", false},
+    },
+    "Attribute": {
+        "name": {1, "string", false},
+        "value": {2, "Value", false},
+    },
+    "Attrs": {
+        "component_id": {1, "int32", false},
+        "attributes": {2, "Attribute", true},
+    },
+    "Count": {
+        "component_id": {1, "int32", false},
+        "query_id": {2, "int32", false},
+        "predicate": {3, "Predicate", false},
+    },
+    "Counted": {
+        "query_id": {1, "int32", false},
+        "count": {2, "int32", false},
+    },
+    "Time": {
+        "component_id": {1, "int32", false},
+        "query_id": {2, "int32", false},
+    },
+    "TimeIs": {
+        "query_id": {1, "int32", false},
+        "unix_nano": {2, "int64", false},
+    },
+    "Beat": {
+        "component_id": {1, "int32", false},
+        "max_message_id": {2, "int32", false},
+        "buffered": {3, "int32", false},
+    },
+    "StatusRequest": {
+        "namespace": {1, "string", false},
+    },
+    "Status": {
+        "next_message_id": {1, "int32", false},
+        "messages_exchanged": {2, "int32", false},
+    },
+    "PendingId": {
+        "message_id": {1, "int32", false},
+        "age_msec": {2, "int64", false},
+    },
+    "ComponentStatus": {
+        "component_id": {1, "int32", false},
+        "identity": {2, "string", false},
+        "idle_msec": {3, "int64", false},
+        "beat_msec": {4, "int64", false},
+        "lag": {5, "int32", false},
+        "buffered": {6, "int32", false},
+        "pending": {7, "PendingId", true},
+    },
+    "StatusEnd": {},
+    "Value": {
+        "attribute": {1, "string", false},
+        "string_value": {2, "string", false},
+        "int_value": {3, "int64", false},
+        "bool_value": {4, "bool", false},
+        "tuple_value": {5, "bytes", false},
+        "unknown": {6, "bool", false},
+    },
+    "Comparison": {
+        "op": {1, "Operator", false},
+        "left": {2, "Value", false},
+        "right": {3, "Value", false},
+    },
+    "IsIn": {
+        "left": {1, "Value", false},
+        "right": {2, "Value", false},
+    },
+    "WithinDistance": {
+        "x": {1, "Value", false},
+        "y": {2, "Value", false},
+        "radius": {3, "Value", false},
+    },
+    "Binary": {
+        "p1": {1, "Predicate", false},
+        "p2": {2, "Predicate", false},
+    },
+    "Predicate": {
+        "comparison": {1, "Comparison", false},
+        "is_in": {2, "IsIn", false},
+        "conjunction": {3, "Binary", false},
+        "disjunction": {4, "Binary", false},
+        "negation": {5, "Predicate", false},
+        "always_true": {6, "bool", false},
+        "always_false": {7, "bool", false},
+        "within_distance": {8, "WithinDistance", false},
+    },
+}
+
+// comparisonOps are the values of Comparison.Operator.
+var comparisonOps = []string{"==", "!=", "<", "<=", ">", ">="}
+
+// The wire types of the protobuf binary format.
+const (
+    wireVarint = 0
+    wireFixed64 = 1
+    wireBytes = 2
+    wireFixed32 = 5
+)
+
+/*
+EncodeFrame encodes a line of the protocol between the agents and the
+CentralServer, given as its tokens (e.g. "REQ", "3"), as a Frame of
+proto/goat.proto in the protobuf binary format. DecodeFrame is its inverse:
+optional trailing fields are omitted when empty or zero, otherwise the tokens
+are given back as they were. Tokens that do not form a line of the protocol
+give an error wrapping ErrInvalidFrame.
+*/
+func EncodeFrame(tokens ...string) ([]byte, error) {
+    if len(tokens) == 0 {
+        return nil, invalidFrame("empty line")
+    }
+    lr := &lineReader{tokens[0], tokens[1:], nil}
+    w := &protoWriter{typ: "Frame"}
+    switch lr.cmd {
+        case "Register":
+            w.message("register", func(m *protoWriter) {
+                m.int("port", lr.int32(0))
+                m.str("identity", lr.optional(1))
+                m.str("namespace", lr.optional(2))
+            })
+        case "Registered":
+            w.message("registered", func(m *protoWriter) {
+                m.int("component_id", lr.int32(0))
+                m.int("first_message_id", lr.int32(1))
+            })
+        case "Rejected", "Fenced":
+            w.message(strings.ToLower(lr.cmd), func(m *protoWriter) {
+                m.str("identity", lr.str(0))
+            })
+        case "REQ":
+            w.message("id_request", func(m *protoWriter) {
+                m.int("component_id", lr.int32(0))
+                if len(lr.params) > 1 {
+                    m.int("priority", lr.int32(1))
+                }
+            })
+        case "RPLY":
+            w.message("id_reply", func(m *protoWriter) {
+                m.int("message_id", lr.int32(0))
+            })
+        case "DATA":
+            w.message("data", func(m *protoWriter) {
+                m.int("message_id", lr.int32(0))
+                m.int("component_id", lr.int32(1))
+                m.predicate("predicate", lr.predicate(2))
+                m.bytes("message", lr.base64(lr.str(3)))
+            })
+        case "MEMBERS":
+            w.message("members", func(m *protoWriter) {
+                m.int("component_id", lr.int32(0))
+            })
+        case "ATTRS":
+            w.message("attrs", func(m *protoWriter) {
+                m.int("component_id", lr.int32(0))
+                for i := 1; i < len(lr.params); i += 2 {
+                    name, enc := lr.str(i), lr.str(i+1)
+                    m.message("attributes", func(a *protoWriter) {
+                        a.str("name", name)
+                        if strings.HasPrefix(enc, "T|") {
+                            // as is, gob may encode the same tuple differently
+                            data := lr.base64(enc[2:])
+                            a.message("value", func(v *protoWriter) {
+                                v.bytes("tuple_value", data)
+                            })
+                            return
+                        }
+                        val, _ := decodeTypedValue(enc)
+                        a.value("value", val, false)
+                    })
+                }
+            })
+        case "COUNT":
+            w.message("count", func(m *protoWriter) {
+                m.int("component_id", lr.int32(0))
+                m.int("query_id", lr.int32(1))
+                m.predicate("predicate", lr.predicate(2))
+            })
+        case "COUNTED":
+            w.message("counted", func(m *protoWriter) {
+                m.int("query_id", lr.int32(0))
+                m.int("count", lr.int32(1))
+            })
+        case "TIME":
+            w.message("time", func(m *protoWriter) {
+                m.int("component_id", lr.int32(0))
+                m.int("query_id", lr.int32(1))
+            })
+        case "TIMEIS":
+            w.message("time_is", func(m *protoWriter) {
+                m.int("query_id", lr.int32(0))
+                m.int("unix_nano", lr.int64(1))
+            })
+        case "BEAT":
+            w.message("beat", func(m *protoWriter) {
+                m.int("component_id", lr.int32(0))
+                m.int("max_message_id", lr.int32(1))
+                m.int("buffered", lr.int32(2))
+            })
+        case "STATUS":
+            // the request has at most the namespace, the reply two numbers
+            if len(lr.params) < 2 {
+                w.message("status_request", func(m *protoWriter) {
+                    m.str("namespace", lr.optional(0))
+                })
+            } else {
+                w.message("status", func(m *protoWriter) {
+                    m.int("next_message_id", lr.int32(0))
+                    m.int("messages_exchanged", lr.int32(1))
+                })
+            }
+        case "COMP":
+            w.message("component_status", func(m *protoWriter) {
+                m.int("component_id", lr.int32(0))
+                m.str("identity", lr.str(1))
+                m.int("idle_msec", lr.int64(2))
+                m.int("beat_msec", lr.int64(3))
+                m.int("lag", lr.int32(4))
+                m.int("buffered", lr.int32(5))
+                for i := 6; i < len(lr.params); i += 2 {
+                    mid, age := lr.int32(i), lr.int64(i+1)
+                    m.message("pending", func(p *protoWriter) {
+                        p.int("message_id", mid)
+                        p.int("age_msec", age)
+                    })
+                }
+            })
+        case "END":
+            w.message("status_end", func(m *protoWriter) {})
+        default:
+            return nil, invalidFrame("unknown command %q", lr.cmd)
+    }
+    if lr.err != nil {
+        return nil, lr.err
+    }
+    return w.buf, nil
+}
+
+/*
+DecodeFrame decodes a Frame of proto/goat.proto, in the protobuf binary format,
+into the tokens of the corresponding line of the protocol (see EncodeFrame).
+*/
+func DecodeFrame(frame []byte) ([]string, error) {
+    f, err := parseProto("Frame", frame)
+    if err != nil {
+        return nil, err
+    }
+    name, m, err := f.oneofMessage()
+    if err != nil {
+        return nil, err
+    }
+    switch name {
+        case "register":
+            tokens := []string{"Register", m.itoa("port")}
+            if identity, namespace := m.str("identity"), m.str("namespace"); namespace != "" {
+                tokens = append(tokens, identity, namespace)
+            } else if identity != "" {
+                tokens = append(tokens, identity)
+            }
+            return tokens, nil
+        case "registered":
+            return []string{"Registered", m.itoa("component_id"), m.itoa("first_message_id")}, nil
+        case "rejected":
+            return []string{"Rejected", m.str("identity")}, nil
+        case "fenced":
+            return []string{"Fenced", m.str("identity")}, nil
+        case "id_request":
+            tokens := []string{"REQ", m.itoa("component_id")}
+            if m.int("priority") != 0 {
+                tokens = append(tokens, m.itoa("priority"))
+            }
+            return tokens, nil
+        case "id_reply":
+            return []string{"RPLY", m.itoa("message_id")}, nil
+        case "data":
+            pred, err := m.predicate("predicate")
+            if err != nil {
+                return nil, err
+            }
+            return []string{"DATA", m.itoa("message_id"), m.itoa("component_id"), pred,
+                base64.StdEncoding.EncodeToString(m.bytes("message"))}, nil
+        case "members":
+            return []string{"MEMBERS", m.itoa("component_id")}, nil
+        case "attrs":
+            tokens := []string{"ATTRS", m.itoa("component_id")}
+            for _, attribute := range m.repeated("attributes") {
+                a, err := parseProto("Attribute", attribute)
+                if err != nil {
+                    return nil, err
+                }
+                val, err := a.typedValue("value")
+                if err != nil {
+                    return nil, err
+                }
+                tokens = append(tokens, a.str("name"), val)
+            }
+            return tokens, nil
+        case "count":
+            pred, err := m.predicate("predicate")
+            if err != nil {
+                return nil, err
+            }
+            return []string{"COUNT", m.itoa("component_id"), m.itoa("query_id"), pred}, nil
+        case "counted":
+            return []string{"COUNTED", m.itoa("query_id"), m.itoa("count")}, nil
+        case "time":
+            return []string{"TIME", m.itoa("component_id"), m.itoa("query_id")}, nil
+        case "time_is":
+            return []string{"TIMEIS", m.itoa("query_id"), m.itoa("unix_nano")}, nil
+        case "beat":
+            return []string{"BEAT", m.itoa("component_id"), m.itoa("max_message_id"), m.itoa("buffered")}, nil
+        case "status_request":
+            if namespace := m.str("namespace"); namespace != "" {
+                return []string{"STATUS", namespace}, nil
+            }
+            return []string{"STATUS"}, nil
+        case "status":
+            return []string{"STATUS", m.itoa("next_message_id"), m.itoa("messages_exchanged")}, nil
+        case "component_status":
+            tokens := []string{"COMP", m.itoa("component_id"), m.str("identity"), m.itoa("idle_msec"),
+                m.itoa("beat_msec"), m.itoa("lag"), m.itoa("buffered")}
+            for _, pending := range m.repeated("pending") {
+                p, err := parseProto("PendingId", pending)
+                if err != nil {
+                    return nil, err
+                }
+                tokens = append(tokens, p.itoa("message_id"), p.itoa("age_msec"))
+            }
+            return tokens, nil
+        case "status_end":
+            return []string{"END"}, nil
+    }
+    return nil, invalidFrame("no command")
+}
+
+func invalidFrame(format string, args ...interface{}) error {
+    return fmt.Errorf("%w: %s", ErrInvalidFrame, fmt.Sprintf(format, args...))
+}
+
+// lineReader reads the parameters of a line of command cmd, remembering the
+// first error.
+type lineReader struct {
+    cmd string
+    params []string
+    err error
+}
+
+func (lr *lineReader) fail(format string, args ...interface{}) {
+    if lr.err == nil {
+        lr.err = invalidFrame(lr.cmd + ": " + format, args...)
+    }
+}
+
+func (lr *lineReader) str(i int) string {
+    if i >= len(lr.params) {
+        lr.fail("missing parameter %d", i+1)
+        return ""
+    }
+    return lr.params[i]
+}
+
+func (lr *lineReader) optional(i int) string {
+    if i >= len(lr.params) {
+        return ""
+    }
+    return lr.params[i]
+}
+
+func (lr *lineReader) parseInt(i int, bits int) int64 {
+    s := lr.str(i)
+    n, err := strconv.ParseInt(s, 10, bits)
+    if err != nil && lr.err == nil {
+        lr.fail("invalid number %q", s)
+    }
+    return n
+}
+
+func (lr *lineReader) int32(i int) int64 {
+    return lr.parseInt(i, 32)
+}
+
+func (lr *lineReader) int64(i int) int64 {
+    return lr.parseInt(i, 64)
+}
+
+func (lr *lineReader) base64(s string) []byte {
+    data, err := base64.StdEncoding.DecodeString(s)
+    if err != nil && lr.err == nil {
+        lr.fail("invalid gob data %q", s)
+    }
+    return data
+}
+
+func (lr *lineReader) predicate(i int) ClosedPredicate {
+    s := lr.str(i)
+    pred, err := ToPredicate(s)
+    if err != nil && lr.err == nil {
+        lr.err = fmt.Errorf("%w: %s: %v", ErrInvalidFrame, lr.cmd, err)
+    }
+    return pred
+}
+
+// protoWriter writes the fields of a message of protoSchema. Like in proto3,
+// the scalar fields with the default value are not written, except in oneofs.
+type protoWriter struct {
+    typ string
+    buf []byte
+}
+
+func (w *protoWriter) tag(name string, wire int) {
+    field, known := protoSchema[w.typ][name]
+    if !known {
+        panic(fmt.Sprintf("no field %s in %s", name, w.typ))
+    }
+    w.uvarint(uint64(field.num) << 3 | uint64(wire))
+}
+
+func (w *protoWriter) uvarint(x uint64) {
+    var buf [binary.MaxVarintLen64]byte
+    w.buf = append(w.buf, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+// varint always writes the field, int skips it if zero.
+func (w *protoWriter) varint(name string, x int64) {
+    w.tag(name, wireVarint)
+    // negative numbers take ten bytes, also for int32 fields
+    w.uvarint(uint64(x))
+}
+
+func (w *protoWriter) int(name string, x int64) {
+    if x != 0 {
+        w.varint(name, x)
+    }
+}
+
+func (w *protoWriter) bytes(name string, data []byte) {
+    w.tag(name, wireBytes)
+    w.uvarint(uint64(len(data)))
+    w.buf = append(w.buf, data...)
+}
+
+func (w *protoWriter) str(name string, s string) {
+    if s != "" {
+        w.bytes(name, []byte(s))
+    }
+}
+
+// message writes the field name, a message written by fnc.
+func (w *protoWriter) message(name string, fnc func(*protoWriter)) {
+    sub := &protoWriter{typ: protoSchema[w.typ][name].typ}
+    fnc(sub)
+    w.bytes(name, sub.buf)
+}
+
+func (w *protoWriter) value(name string, x interface{}, isAttr bool) {
+    w.message(name, func(v *protoWriter) {
+        if isAttr {
+            v.bytes("attribute", []byte(x.(string)))
+            return
+        }
+        switch val := x.(type) {
+            case string:
+                v.bytes("string_value", []byte(val))
+            case int:
+                v.varint("int_value", int64(val))
+            case bool:
+                if val {
+                    v.varint("bool_value", 1)
+                } else {
+                    v.varint("bool_value", 0)
+                }
+            case Tuple:
+                data, _ := base64.StdEncoding.DecodeString(val.encode())
+                v.bytes("tuple_value", data)
+            default:
+                v.varint("unknown", 1)
+        }
+    })
+}
+
+func (w *protoWriter) predicate(name string, pred ClosedPredicate) {
+    if pred == nil {
+        // invalid, the error is in the lineReader
+        return
+    }
+    w.message(name, func(p *protoWriter) {
+        switch pr := pred.(type) {
+            case ccomp:
+                p.message("comparison", func(c *protoWriter) {
+                    for i, op := range comparisonOps {
+                        if op == pr.Op {
+                            c.int("op", int64(i))
+                        }
+                    }
+                    c.value("left", pr.Par1, pr.IsAttr1)
+                    c.value("right", pr.Par2, pr.IsAttr2)
+                })
+            case cisin:
+                p.message("is_in", func(c *protoWriter) {
+                    c.value("left", pr.Par1, pr.IsAttr1)
+                    c.value("right", pr.Par2, pr.IsAttr2)
+                })
+            case cdist:
+                p.message("within_distance", func(c *protoWriter) {
+                    c.value("x", pr.X, pr.IsAttrX)
+                    c.value("y", pr.Y, pr.IsAttrY)
+                    c.value("radius", pr.R, pr.IsAttrR)
+                })
+            case cand:
+                p.message("conjunction", func(b *protoWriter) {
+                    b.predicate("p1", pr.p1)
+                    b.predicate("p2", pr.p2)
+                })
+            case cor:
+                p.message("disjunction", func(b *protoWriter) {
+                    b.predicate("p1", pr.p1)
+                    b.predicate("p2", pr.p2)
+                })
+            case cnot:
+                p.predicate("negation", pr.p)
+            case _true:
+                p.varint("always_true", 1)
+            case _false:
+                p.varint("always_false", 1)
+        }
+    })
+}
+
+// protoValue is a field read from the wire.
+type protoValue struct {
+    num int
+    wire int
+    varint uint64
+    data []byte
+}
+
+// protoMessage is a message of protoSchema read from the wire.
+type protoMessage struct {
+    typ string
+    fields []protoValue
+}
+
+func parseProto(message string, data []byte) (protoMessage, error) {
+    m := protoMessage{typ: message}
+    for len(data) > 0 {
+        key, n := binary.Uvarint(data)
+        if n <= 0 {
+            return m, invalidFrame("%s: truncated", message)
+        }
+        data = data[n:]
+        f := protoValue{num: int(key >> 3), wire: int(key & 7)}
+        switch f.wire {
+            case wireVarint:
+                f.varint, n = binary.Uvarint(data)
+            case wireBytes:
+                var length uint64
+                length, n = binary.Uvarint(data)
+                if n > 0 && length <= uint64(len(data) - n) {
+                    f.data = data[n:n+int(length)]
+                    n += int(length)
+                } else {
+                    n = 0
+                }
+            case wireFixed64, wireFixed32:
+                // unknown fields of future versions
+                n = 8
+                if f.wire == wireFixed32 {
+                    n = 4
+                }
+                if n > len(data) {
+                    n = 0
+                }
+            default:
+                n = 0
+        }
+        if n <= 0 {
+            return m, invalidFrame("%s: truncated", message)
+        }
+        data = data[n:]
+        m.fields = append(m.fields, f)
+    }
+    return m, nil
+}
+
+// get returns the last occurrence of field name with the wire type of its type,
+// as the protobuf parsers do.
+func (m protoMessage) get(name string) (protoValue, bool) {
+    field := protoSchema[m.typ][name]
+    wire := wireVarint
+    if _, isMessage := protoSchema[field.typ]; isMessage || field.typ == "string" || field.typ == "bytes" {
+        wire = wireBytes
+    }
+    var val protoValue
+    found := false
+    for _, f := range m.fields {
+        if f.num == field.num && f.wire == wire {
+            val, found = f, true
+        }
+    }
+    return val, found
+}
+
+func (m protoMessage) int(name string) int64 {
+    f, _ := m.get(name)
+    if protoSchema[m.typ][name].typ == "int64" {
+        return int64(f.varint)
+    }
+    return int64(int32(f.varint))
+}
+
+func (m protoMessage) itoa(name string) string {
+    return strconv.FormatInt(m.int(name), 10)
+}
+
+func (m protoMessage) bytes(name string) []byte {
+    f, _ := m.get(name)
+    return f.data
+}
+
+func (m protoMessage) str(name string) string {
+    return string(m.bytes(name))
+}
+
+func (m protoMessage) repeated(name string) [][]byte {
+    num := protoSchema[m.typ][name].num
+    var all [][]byte
+    for _, f := range m.fields {
+        if f.num == num && f.wire == wireBytes {
+            all = append(all, f.data)
+        }
+    }
+    return all
+}
+
+// oneof returns the name of the last field set among those known.
+func (m protoMessage) oneof() (string, bool) {
+    for i := len(m.fields) - 1; i >= 0; i-- {
+        for name, field := range protoSchema[m.typ] {
+            if field.num == m.fields[i].num {
+                if _, present := m.get(name); present {
+                    return name, true
+                }
+            }
+        }
+    }
+    return "", false
+}
+
+// oneofMessage returns the field set in the oneof of m, a message.
+func (m protoMessage) oneofMessage() (string, protoMessage, error) {
+    name, set := m.oneof()
+    if !set {
+        return "", protoMessage{}, invalidFrame("%s: no field set", m.typ)
+    }
+    sub, err := parseProto(protoSchema[m.typ][name].typ, m.bytes(name))
+    return name, sub, err
+}
+
+func (m protoMessage) value(name string) (interface{}, bool, error) {
+    v, err := parseProto("Value", m.bytes(name))
+    if err != nil {
+        return nil, false, err
+    }
+    kind, set := v.oneof()
+    switch kind {
+        case "attribute":
+            return v.str(kind), true, nil
+        case "string_value":
+            return v.str(kind), false, nil
+        case "int_value":
+            return int(v.int(kind)), false, nil
+        case "bool_value":
+            return v.int(kind) != 0, false, nil
+        case "tuple_value":
+            t, err := decodeTupleErr(base64.StdEncoding.EncodeToString(v.bytes(kind)))
+            if err != nil {
+                return nil, false, invalidFrame("Value: %v", err)
+            }
+            return t, false, nil
+    }
+    if !set {
+        return nil, false, invalidFrame("Value: no field set")
+    }
+    // unknown
+    return nil, false, nil
+}
+
+// typedValue returns the field name, a Value, in the form of encodeTypedValue.
+func (m protoMessage) typedValue(name string) (string, error) {
+    v, err := parseProto("Value", m.bytes(name))
+    if err != nil {
+        return "", err
+    }
+    kind, _ := v.oneof()
+    switch kind {
+        case "tuple_value":
+            return "T|" + base64.StdEncoding.EncodeToString(v.bytes(kind)), nil
+        case "attribute":
+            return "", invalidFrame("Value: attribute %q out of a predicate", v.str(kind))
+    }
+    val, _, err := m.value(name)
+    return encodeTypedValue(val), err
+}
+
+// predicate returns the field name, a Predicate, in its text form.
+func (m protoMessage) predicate(name string) (string, error) {
+    p, err := m.closedPredicate(name)
+    if err != nil {
+        return "", err
+    }
+    return p.String(), nil
+}
+
+func (m protoMessage) closedPredicate(name string) (ClosedPredicate, error) {
+    p, err := parseProto("Predicate", m.bytes(name))
+    if err != nil {
+        return nil, err
+    }
+    kind, set := p.oneof()
+    if !set {
+        return nil, invalidFrame("Predicate: no field set")
+    }
+    switch kind {
+        case "always_true":
+            return True(), nil
+        case "always_false":
+            return False(), nil
+        case "negation":
+            neg, err := p.closedPredicate(kind)
+            return cnot{neg}, err
+    }
+    _, sub, err := p.oneofMessage()
+    if err != nil {
+        return nil, err
+    }
+    // the operands, in order
+    var names []string
+    switch kind {
+        case "comparison", "is_in":
+            names = []string{"left", "right"}
+        case "within_distance":
+            names = []string{"x", "y", "radius"}
+        case "conjunction", "disjunction":
+            p1, err := sub.closedPredicate("p1")
+            if err != nil {
+                return nil, err
+            }
+            p2, err := sub.closedPredicate("p2")
+            if err != nil {
+                return nil, err
+            }
+            if kind == "conjunction" {
+                return cand{p1, p2}, nil
+            }
+            return cor{p1, p2}, nil
+    }
+    vals := make([]interface{}, len(names))
+    isAttrs := make([]bool, len(names))
+    for i, operand := range names {
+        if vals[i], isAttrs[i], err = sub.value(operand); err != nil {
+            return nil, err
+        }
+    }
+    switch kind {
+        case "comparison":
+            op := sub.int("op")
+            if op < 0 || op >= int64(len(comparisonOps)) {
+                return nil, invalidFrame("Comparison: unknown operator %d", op)
+            }
+            return ccomp{vals[0], isAttrs[0], comparisonOps[op], vals[1], isAttrs[1]}, nil
+        case "is_in":
+            return cisin{vals[0], isAttrs[0], vals[1], isAttrs[1]}, nil
+        default:
+            return cdist{vals[0], isAttrs[0], vals[1], isAttrs[1], vals[2], isAttrs[2]}, nil
+    }
+}
@@ -0,0 +1,163 @@
+package goat
+
+import (
+    "bufio"
+    "errors"
+    "os"
+    "reflect"
+    "regexp"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+// readProtoSchema reads the messages of a .proto file, with their fields.
+func readProtoSchema(t *testing.T, path string) map[string]map[string]protoField {
+    file, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer file.Close()
+    block := regexp.MustCompile(`^(message|enum|oneof) (\w+) \{$`)
+    field := regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+);$`)
+    schema := map[string]map[string]protoField{}
+    // the blocks open at the current line: kinds and message names
+    var kinds, messages []string
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if i := strings.Index(line, "//"); i >= 0 {
+            line = line[:i]
+        }
+        line = strings.TrimSpace(line)
+        if m := block.FindStringSubmatch(line); m != nil {
+            kinds = append(kinds, m[1])
+            if m[1] == "message" {
+                messages = append(messages, m[2])
+                schema[m[2]] = map[string]protoField{}
+            }
+        } else if line == "}" {
+            if kinds[len(kinds)-1] == "message" {
+                messages = messages[:len(messages)-1]
+            }
+            kinds = kinds[:len(kinds)-1]
+        } else if m := field.FindStringSubmatch(line); m != nil && kinds[len(kinds)-1] != "enum" {
+            num, _ := strconv.Atoi(m[4])
+            schema[messages[len(messages)-1]][m[3]] = protoField{num, m[2], m[1] != ""}
+        }
+    }
+    return schema
+}
+
+func TestProtoSchema(t *testing.T) {
+    schema := readProtoSchema(t, "../proto/goat.proto")
+    if !reflect.DeepEqual(schema, protoSchema) {
+        for message, fields := range schema {
+            if !reflect.DeepEqual(fields, protoSchema[message]) {
+                t.Errorf("%s: the .proto has %v, the codec %v", message, fields, protoSchema[message])
+            }
+        }
+        for message := range protoSchema {
+            if _, has := schema[message]; !has {
+                t.Errorf("%s is not in the .proto", message)
+            }
+        }
+    }
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+    attr := NewAttributes()
+    pred := And(
+        Equals(Receiver("role"), "worker"),
+        Or(Not(Belong(Receiver("zone"), NewTuple("a", 1))), WithinDistance(-3, 4, Receiver("range"))),
+        LessThanOrEqual(Receiver("load"), 0),
+        Or(True(), False()),
+        NotEquals(Receiver("on"), false),
+        GreaterThan(Receiver("unset"), nil),
+    ).CloseUnder(attr).String()
+    body, zone := NewTuple("hello", 42, true), NewTuple("x", 1)
+    msg, tuple := body.encode(), "T|" + zone.encode()
+    lines := [][]string{
+        {"Register", "4000"},
+        {"Register", "4000", "worker 1"},
+        {"Register", "4000", "", "tenant"},
+        {"Register", "4000", "worker 1", "tenant"},
+        {"Registered", "3", "17"},
+        {"Rejected", "worker 1"},
+        {"Fenced", "worker 1"},
+        {"REQ", "3"},
+        {"REQ", "3", "-2"},
+        {"RPLY", "18"},
+        {"DATA", "18", "3", pred, msg},
+        {"DATA", "19", "-1", True().String(), msg},
+        {"MEMBERS", "3"},
+        {"ATTRS", "3"},
+        {"ATTRS", "3", "role", "S|worker", "load", "I|0", "on", "B|false", "zone", tuple},
+        {"COUNT", "3", "1", pred},
+        {"COUNTED", "1", "0"},
+        {"TIME", "3", "2"},
+        {"TIMEIS", "2", "1700000000123456789"},
+        {"BEAT", "3", "17", "0"},
+        {"STATUS"},
+        {"STATUS", "tenant"},
+        {"STATUS", "20", "123"},
+        {"COMP", "3", "", "12", "-1", "-1", "-1"},
+        {"COMP", "3", "worker 1", "12", "800", "2", "1", "18", "40", "19", "0"},
+        {"END"},
+    }
+    for _, line := range lines {
+        frame, err := EncodeFrame(line...)
+        if err != nil {
+            t.Fatal(line, err)
+        }
+        decoded, err := DecodeFrame(frame)
+        if err != nil {
+            t.Fatal(line, err)
+        }
+        if !reflect.DeepEqual(decoded, line) {
+            t.Errorf("%q was decoded as %q", line, decoded)
+        }
+    }
+}
+
+func TestFrameWireFormat(t *testing.T) {
+    // Frame.id_request (5, length-delimited) {component_id: 3, priority: 2}
+    frame, err := EncodeFrame("REQ", "3", "2")
+    if err != nil || !reflect.DeepEqual(frame, []byte{0x2a, 0x04, 0x08, 0x03, 0x18, 0x02}) {
+        t.Fatalf("% x %v", frame, err)
+    }
+    // negative int32 fields take ten bytes; unknown fields are skipped
+    frame = []byte{0x72, 0x10, 0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0x25, 1, 2, 3, 4}
+    if tokens, err := DecodeFrame(frame); err != nil || !reflect.DeepEqual(tokens, []string{"BEAT", "-1", "0", "0"}) {
+        t.Fatal(tokens, err)
+    }
+}
+
+func TestInvalidFrames(t *testing.T) {
+    lines := [][]string{
+        {},
+        {"HELLO"},
+        {"REQ"},
+        {"REQ", "three"},
+        {"RPLY", "4294967296"},
+        {"DATA", "1", "2", "bad", "AA=="},
+        {"DATA", "1", "2", "TT", "not base64!"},
+        {"ATTRS", "3", "role"},
+    }
+    for _, line := range lines {
+        if _, err := EncodeFrame(line...); !errors.Is(err, ErrInvalidFrame) {
+            t.Errorf("%q: %v", line, err)
+        }
+    }
+    frames := [][]byte{
+        {},
+        {0x2a, 0x10, 0x08},
+        {0x3a, 0x02, 0x1a, 0x00},
+        {0x4a, 0x04, 0x12, 0x02, 0x0a, 0x00},
+    }
+    for _, frame := range frames {
+        if _, err := DecodeFrame(frame); !errors.Is(err, ErrInvalidFrame) {
+            t.Errorf("% x: %v", frame, err)
+        }
+    }
+}
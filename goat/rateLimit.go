@@ -0,0 +1,134 @@
+package goat
+
+import (
+    "sync"
+    "time"
+)
+
+/*
+SendRatePolicy states what happens to the sends in excess of the rate set with
+Component.SetSendRate.
+*/
+type SendRatePolicy int
+
+const (
+    // SendRateWait makes the excess sends wait for their turn.
+    SendRateWait SendRatePolicy = iota
+    // SendRateError makes the excess sends performed on behalf of code running
+    // outside processes (e.g. by Component.Send) fail with ErrRateLimited;
+    // sends by processes still wait.
+    SendRateError SendRatePolicy = iota
+)
+
+// tokenBucket holds up to burst tokens, refilled at rate tokens per second
+// according to the clock now.
+type tokenBucket struct {
+    lock *sync.Mutex
+    now func() time.Time
+    rate float64
+    burst float64
+    tokens float64
+    last time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now func() time.Time) *tokenBucket {
+    if burst < 1 {
+        burst = 1
+    }
+    return &tokenBucket{
+        lock: &sync.Mutex{},
+        now: now,
+        rate: rate,
+        burst: float64(burst),
+        tokens: float64(burst),
+        last: now(),
+    }
+}
+
+func (tb *tokenBucket) refill() {
+    now := tb.now()
+    tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+    if tb.tokens > tb.burst {
+        tb.tokens = tb.burst
+    }
+    tb.last = now
+}
+
+// reserve takes a token, possibly not available yet, and returns how long to
+// wait for it.
+func (tb *tokenBucket) reserve() time.Duration {
+    tb.lock.Lock()
+    defer tb.lock.Unlock()
+    tb.refill()
+    tb.tokens--
+    if tb.tokens >= 0 {
+        return 0
+    }
+    return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// refund gives back a token taken with reserve or take and not used.
+func (tb *tokenBucket) refund() {
+    tb.lock.Lock()
+    defer tb.lock.Unlock()
+    tb.refill()
+    tb.tokens++
+    if tb.tokens > tb.burst {
+        tb.tokens = tb.burst
+    }
+}
+
+// take takes a token if one is available now.
+func (tb *tokenBucket) take() bool {
+    tb.lock.Lock()
+    defer tb.lock.Unlock()
+    tb.refill()
+    if tb.tokens < 1 {
+        return false
+    }
+    tb.tokens--
+    return true
+}
+
+/*
+SetSendRate limits the sends of c to rate per second on average, with bursts of
+up to burst sends, so that a process sending in a tight loop cannot flood the
+infrastructure. Sends in excess wait or, for the sends performed outside
+processes with SendRateError, fail. A choice offering a send waits for its turn
+before trying to send, but uses up a send only if it ends up sending. A rate of
+0 removes the limit. It must be called before the component is started.
+*/
+func (c *Component) SetSendRate(rate float64, burst int, policy SendRatePolicy) {
+    if rate <= 0 {
+        c.sendLimiter = nil
+        return
+    }
+    c.sendLimiter = newTokenBucket(rate, burst, timeNow)
+    c.sendRatePolicy = policy
+}
+
+// reserveSend reserves a send and returns how long a process must wait before
+// starting it, and false if the sends of c are not limited.
+func (c *Component) reserveSend() (time.Duration, bool) {
+    if c.sendLimiter == nil {
+        return 0, false
+    }
+    return c.sendLimiter.reserve(), true
+}
+
+// reserveSend reserves a send for p, using the token already taken for it if
+// any, and returns how long p must wait before asking for its mids, and false
+// if the sends are not limited. Unless p sends, the reservation must be given
+// back with unreserveSend.
+func (p *Process) reserveSend() (time.Duration, bool) {
+    if p.tokenTaken {
+        p.tokenTaken = false
+        return 0, true
+    }
+    return p.Comp.reserveSend()
+}
+
+// unreserveSend gives back a send reserved by p and not performed.
+func (p *Process) unreserveSend() {
+    p.Comp.sendLimiter.refund()
+}
@@ -0,0 +1,58 @@
+package goat
+
+import (
+    "testing"
+    "time"
+)
+
+func TestTokenBucket(t *testing.T) {
+    now := time.Unix(0, 0)
+    tb := newTokenBucket(10, 2, func() time.Time { return now })
+    if !tb.take() || !tb.take() {
+        t.Fatal("the burst must be available at once")
+    }
+    if tb.take() {
+        t.Fatal("the bucket must be empty after the burst")
+    }
+    if wait := tb.reserve(); wait != 100 * time.Millisecond {
+        t.Fatal("unexpected wait", wait)
+    }
+    tb.refund()
+    if tb.take() {
+        t.Fatal("a refund must only pay the debt back")
+    }
+    now = now.Add(time.Second)
+    if !tb.take() || !tb.take() || tb.take() {
+        t.Fatal("the bucket must refill up to the burst")
+    }
+    tb.refund()
+    tb.refund()
+    tb.refund()
+    if !tb.take() || !tb.take() || tb.take() {
+        t.Fatal("refunds must not exceed the burst")
+    }
+}
+
+func TestSendRateRefund(t *testing.T) {
+    server := testServer(17737)
+    c := NewComponent(NewSingleServerAgent(server), nil)
+    c.SetSendRate(2, 1, SendRateWait)
+    elapsed := make(chan time.Duration)
+    c.Start(func(p *Process) {
+        // choices that offer a send but never send, and plain receives
+        for i := 0; i < 10; i++ {
+            p.SelectWithin(10, ZeroProcess,
+                Case(False(), ThenSend(NewTuple("never"), True()), ZeroProcess),
+            )
+            p.SelectWithin(10, ZeroProcess,
+                Case(True(), ThenReceive(func(*Attributes, Tuple) bool { return false }), ZeroProcess),
+            )
+        }
+        start := time.Now()
+        p.Send(NewTuple("now"), True())
+        elapsed <- time.Since(start)
+    })
+    if wait := <-elapsed; wait > 250 * time.Millisecond {
+        t.Error("the sends not performed must be given back, the send waited", wait)
+    }
+}
@@ -1,7 +1,9 @@
 package goat
 
-import "time"
-import "sync"
+import (
+    "fmt"
+    "sync"
+)
 
 type msgTime struct {
     id int
@@ -24,6 +26,7 @@ type RingAgent struct{
     chnSendTime *unboundChanMT
     lockST *sync.Mutex
     chnGetMid *unboundChanUnit
+    onError func(error)
 }
 
 func NewRingAgent(registrationAddress string) *RingAgent{
@@ -43,6 +46,21 @@ func NewRingAgent(registrationAddress string) *RingAgent{
     return &ca
 }
 
+func (ca *RingAgent) SetErrorHandler(handler func(error)) {
+    ca.onError = handler
+}
+
+func (ca *RingAgent) reportError(err error) {
+    if ca.onError != nil {
+        ca.onError(err)
+    }
+}
+
+// NewSibling returns a new agent for the same ring.
+func (ca *RingAgent) NewSibling() Agent {
+    return NewRingAgent(ca.registrationAddress)
+}
+
 func (ca *RingAgent) Start(){
     var chnReady chan(struct{})
     ca.listener, chnReady, ca.listeningPort = listenerRandomPort()
@@ -59,8 +77,13 @@ func (ca *RingAgent) Start(){
     dprintln("Starting at mid", ca.firstMessageId)
     
     go func() {
+        defer recoverTo("the agent", ca.onError)
         for {
-            cmd, params := connNode.Receive()
+            cmd, params, err := connNode.ReceiveErr()
+            if err != nil {
+                ca.reportError(fmt.Errorf("%w: %v", ErrDisconnected, err))
+                return
+            }
             switch(cmd) {
                 case "RPLY":
                     mid := atoi(params[0])
@@ -68,7 +91,7 @@ func (ca *RingAgent) Start(){
                     dprintln("r",mid,ca.componentId)
                     
                 case "DATA":
-                    pred, _ := ToPredicate(params[2])
+                    pred := decodePredicate(params[2], ca.onError)
                     mid := atoi(params[0])
                     if ca.firstMessageId >= 0 && mid >= ca.firstMessageId {
                         //cid := atoi(params[1])
@@ -78,7 +101,7 @@ func (ca *RingAgent) Start(){
                             Pred: pred,
                             Message: decodeTuple(params[3]),
                         }
-                        rtime := time.Now().UnixNano()
+                        rtime := timeNow().UnixNano()
                         ca.lockST.Lock()
                         if mid > ca.maxMid{
                             ca.maxMid = mid
@@ -92,11 +115,14 @@ func (ca *RingAgent) Start(){
         }
     }()
     go func(){
+        defer recoverTo("the agent", ca.onError)
         for {
             select {
                 case msgToSend := <- ca.chnMessagesOut:
-                    stime := time.Now().UnixNano()
-                    connNode.Send("DATA", itoa(msgToSend.Id), itoa(ca.componentId), msgToSend.Pred.String(), msgToSend.Message.encode() )
+                    stime := timeNow().UnixNano()
+                    if err := connNode.Send("DATA", itoa(msgToSend.Id), itoa(ca.componentId), msgToSend.Pred.String(), msgToSend.Message.encode() ); err != nil {
+                        ca.reportError(fmt.Errorf("%w: %v", ErrDisconnected, err))
+                    }
                     dprintln("+", msgToSend)
                     ca.lockST.Lock()
                     if msgToSend.Id > ca.maxMid{
@@ -105,7 +131,9 @@ func (ca *RingAgent) Start(){
                     ca.lockST.Unlock()
                     ca.chnSendTime.In <- msgTime{msgToSend.Id, stime}
                 case <- ca.chnGetMid.Out:
-                    connNode.Send("REQ", itoa(ca.componentId))
+                    if err := connNode.Send("REQ", itoa(ca.componentId)); err != nil {
+                        ca.reportError(fmt.Errorf("%w: %v", ErrDisconnected, err))
+                    }
                     dprintln("R?")
             }
         }
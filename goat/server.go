@@ -2,21 +2,77 @@ package goat
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+/*
+DuplicateIdentityPolicy states what a CentralServer does when a component
+registers with the identity of a component that is still connected (see
+SingleServerAgent.SetIdentity).
+*/
+type DuplicateIdentityPolicy int
+
+const (
+    // RejectDuplicate refuses the newcomer, which is stopped.
+    RejectDuplicate DuplicateIdentityPolicy = iota
+    // FenceDuplicate disconnects the old session, which is stopped, and
+    // registers the newcomer.
+    FenceDuplicate DuplicateIdentityPolicy = iota
+)
+
+/*
+CentralServer is the infrastructure made of a single server, which assigns the
+message ids and relays the messages. It can host several isolated systems
+(tenants): the components connecting to "host:port/namespace" (see
+NewSingleServerAgent) interact only with those of the same namespace, with
+which they share their own total order, membership and census. "host:port" is
+the default namespace.
+*/
 type CentralServer struct {
 	nextCompId           int
-	nextMsgId            int
+	nextMsgIds           map[string]int
 	//compAddresses        map[int]string
 	listener             net.Listener
 	messagesExchanged    int
 	lock *sync.Mutex
 	compConnOut map[int]net.Conn
 	compConnIn map[int]*bufio.Reader
+	published map[int]*Attributes
+	pendingMids map[int]map[int]time.Time
+	lastSeen map[int]time.Time
+	beats map[int]heartbeat
+	identityOf map[int]string
+	namespaceOf map[int]string
+	membershipSubs map[int]struct{}
+	identities map[namespacedName]int
+	duplicatePolicy DuplicateIdentityPolicy
+	prioritySequencing bool
+	waitingReqs map[string][]midRequest
+	nextReqSeq int
+	onError func(error)
+}
+
+// heartbeat is the last BEAT of a component: when it was received, how many
+// messages sequenced so far the component had not processed, and how many of
+// them it had received.
+type heartbeat struct {
+    at time.Time
+    lag int
+    buffered int
+}
+
+// midRequest is a REQ waiting for a mid under priority sequencing; seq keeps
+// the requests with the same priority in arrival order.
+type midRequest struct {
+    cid int
+    priority int
+    seq int
 }
 
 func (srv *CentralServer) sendToComponent(cid int, tokens ...string) {
@@ -26,13 +82,17 @@ func (srv *CentralServer) sendToComponent(cid int, tokens ...string) {
 	}
 	//dprintln("Dialing", cid)
 	for successComm := false; !successComm; {
-		conn := srv.compConnOut[cid]//, err := net.Dial("tcp", srv.compAddresses[cid])
+		conn, connected := srv.compConnOut[cid]//, err := net.Dial("tcp", srv.compAddresses[cid])
+		if !connected {
+		    return
+		}
 //		if err == nil {
 		    dprintln("Dialed", cid)
 	        dprintln("Writing", cid)
 			_, errf := fmt.Fprintf(conn, "%s\n", strings.Join(escTokens, " "))
 			if errf != nil {
-			    panic(errf)
+			    srv.dropComponent(cid)
+			    return
 			}
 	        dprintln("Written", cid)
 			srv.messagesExchanged++
@@ -74,83 +134,451 @@ func (srv *CentralServer) receive() (string, []string, string) {
 	}
 }*/
 
+/*
+SetDuplicateIdentityPolicy sets what srv does when two components register with
+the same identity (RejectDuplicate by default).
+*/
+func (srv *CentralServer) SetDuplicateIdentityPolicy(policy DuplicateIdentityPolicy) {
+    srv.lock.Lock()
+    srv.duplicatePolicy = policy
+    srv.lock.Unlock()
+}
+
+/*
+SetErrorHandler sets a function called with the errors of srv, e.g. a panic
+recovered while serving a malformed line, which is then dropped, or a line
+longer than 16 MiB, whose connection is then closed. By default they are
+ignored.
+*/
+func (srv *CentralServer) SetErrorHandler(handler func(error)) {
+    srv.lock.Lock()
+    srv.onError = handler
+    srv.lock.Unlock()
+}
+
+// reportError passes err to the error handler, if any. It must be called
+// without holding srv.lock.
+func (srv *CentralServer) reportError(err error) {
+    srv.lock.Lock()
+    handler := srv.onError
+    srv.lock.Unlock()
+    if handler != nil {
+        handler(err)
+    } else {
+        dprintln("central server error:", err)
+    }
+}
+
+/*
+SetPrioritySequencing makes srv order the sends of all the components by
+priority (see Process.SetPriority): srv assigns one message id at a time per
+namespace, and each time it gives the next one to the waiting send with the
+highest priority (the oldest one among equals). A higher-priority send
+therefore overtakes the lower-priority ones waiting in any component, at the
+cost of serializing the sends. Off by default, in which case message ids are
+assigned on arrival and priorities only order the sends of each component.
+It must be called before the components connect.
+*/
+func (srv *CentralServer) SetPrioritySequencing(on bool) {
+    srv.lock.Lock()
+    srv.prioritySequencing = on
+    srv.lock.Unlock()
+}
+
+// assignMid assigns the next mid of namespace to cid and sends it. It must be
+// called holding srv.lock.
+func (srv *CentralServer) assignMid(cid int, namespace string) {
+    mid := srv.newMid(namespace)
+    if _, has := srv.pendingMids[cid]; !has {
+        srv.pendingMids[cid] = map[int]time.Time{}
+    }
+    srv.pendingMids[cid][mid] = timeNow()
+    dprintln("Sending RPLY to",cid)
+    srv.sendToComponent(cid, "RPLY", itoa(mid))
+}
+
+// grantWaiting assigns a mid to the waiting request of namespace with the
+// highest priority, unless a mid of namespace is still unused. It must be
+// called holding srv.lock.
+func (srv *CentralServer) grantWaiting(namespace string) {
+    for _, cid := range srv.peers(namespace) {
+        if len(srv.pendingMids[cid]) > 0 {
+            return
+        }
+    }
+    waiting := srv.waitingReqs[namespace]
+    if len(waiting) == 0 {
+        return
+    }
+    best := 0
+    for i, req := range waiting {
+        if req.priority > waiting[best].priority ||
+            req.priority == waiting[best].priority && req.seq < waiting[best].seq {
+            best = i
+        }
+    }
+    req := waiting[best]
+    srv.waitingReqs[namespace] = append(waiting[:best:best], waiting[best+1:]...)
+    srv.assignMid(req.cid, namespace)
+}
+
 func (srv *CentralServer) GetMessagesExchanged() int {
 	return srv.messagesExchanged
 }
 
 func (srv *CentralServer) ListenReg() {
     for{
-        conn, _ := srv.listener.Accept()
+        conn, err := srv.listener.Accept()
+        if errors.Is(err, net.ErrClosed) {
+            return
+        } else if err != nil {
+            continue
+        }
         bconn := bufio.NewReader(conn)
 	    myAddressPort := conn.RemoteAddr().String()
 	    portIndex := strings.LastIndex(myAddressPort, ":")
 	    address := myAddressPort[:portIndex]
         dprintln("!")
-	    serverMsg, err := bufio.NewReader(conn).ReadString('\n')
-	    if err == nil {
-	        dprintln("Accept:",serverMsg)
-		    escTokens := strings.Split(serverMsg[:len(serverMsg)-1], " ")
-		    tokens := make([]string, len(escTokens))
-		    for i, escTok := range escTokens {
-			    tokens[i], _ = unescape(escTok, 0)
-		    }
-		    cPort := tokens[1]
-		    srv.lock.Lock()
-		    srv.messagesExchanged++
-			cid := srv.nextCompId
-			srv.nextCompId++
-			srv.compConnIn[cid] = bconn
-			connOut, err := net.Dial("tcp", address + ":" + cPort)
-			if err != nil {
-			    panic(err)
-			}
-			srv.compConnOut[cid] = connOut
-			srv.sendToComponent(cid, "Registered", itoa(cid), itoa(srv.nextMsgId))
-			go func(id int, bcon *bufio.Reader){srv.ListenConn(id, bcon)}(cid, bconn)
-		    srv.lock.Unlock()
-	    } 
+	    serverMsg, err := readLine(bconn)
+	    if err != nil {
+	        if err == errLineTooLong {
+	            srv.reportError(fmt.Errorf("%s: %w", address, err))
+	        }
+	        conn.Close()
+	        continue
+	    }
+        dprintln("Accept:",serverMsg)
+	    tokens := splitLine(serverMsg)
+	    switch {
+	        case tokens[0] == "STATUS": // STATUS [namespace]
+		        namespace := ""
+		        if len(tokens) > 1 {
+		            namespace = tokens[1]
+		        }
+		        srv.writeStatus(conn, namespace)
+		        conn.Close()
+		    case tokens[0] == "Register" && len(tokens) > 1: // Register port [identity [namespace]]
+		        if !catchPanic("the central server", srv.reportError, func() {
+		            srv.register(conn, bconn, address, tokens[1:])
+		        }) {
+		            conn.Close()
+		        }
+		    default:
+		        conn.Close()
+	    }
+    }
+}
+
+// register registers the component that connected on conn from address.
+func (srv *CentralServer) register(conn net.Conn, bconn *bufio.Reader, address string, params []string) {
+    cPort := params[0]
+    identity, namespace := "", ""
+    if len(params) > 1 {
+        identity = params[1]
+    }
+    if len(params) > 2 {
+        namespace = params[2]
     }
+	connOut, err := net.Dial("tcp", address + ":" + cPort)
+	if err != nil {
+	    srv.reportError(fmt.Errorf("goat: cannot connect back to %s:%s: %w", address, cPort, err))
+	    conn.Close()
+	    return
+	}
+    srv.lock.Lock()
+    defer srv.lock.Unlock()
+    srv.messagesExchanged++
+	if old, taken := srv.identities[namespaced(namespace, identity)]; identity != "" && taken {
+	    if srv.duplicatePolicy == RejectDuplicate {
+	        fmt.Fprintf(connOut, "%s\n", encodeLine("Rejected", identity))
+	        connOut.Close()
+	        conn.Close()
+	        return
+	    }
+	    srv.sendToComponent(old, "Fenced", identity)
+	    srv.dropComponent(old)
+	}
+	cid := srv.nextCompId
+	srv.nextCompId++
+	srv.compConnIn[cid] = bconn
+	srv.compConnOut[cid] = connOut
+	srv.lastSeen[cid] = timeNow()
+	srv.namespaceOf[cid] = namespace
+	if identity != "" {
+	    srv.identities[namespaced(namespace, identity)] = cid
+	    srv.identityOf[cid] = identity
+	}
+	srv.sendToComponent(cid, "Registered", itoa(cid), itoa(srv.nextMsgIds[namespace]))
+	srv.notifyMembership(MembershipEvent{ComponentJoined, cid, identity}, namespace, srv.membershipSubs)
+	go func(id int, bcon *bufio.Reader, con net.Conn){
+	    srv.ListenConn(id, bcon)
+	    con.Close()
+	}(cid, bconn, conn)
+}
+
+// splitLine splits a line of the protocol, terminated by '\n', into its
+// unescaped tokens.
+func splitLine(line string) []string {
+    escTokens := strings.Split(line[:len(line)-1], " ")
+    tokens := make([]string, len(escTokens))
+    for i, escTok := range escTokens {
+        tokens[i], _ = unescape(escTok, 0)
+    }
+    return tokens
+}
+
+// dropComponent forgets the disconnected component cid. The mids it was
+// assigned but did not use are filled with empty messages, otherwise the other
+// components would wait for them forever. It must be called holding srv.lock.
+func (srv *CentralServer) dropComponent(cid int) {
+    conn, connected := srv.compConnOut[cid]
+    if !connected {
+        return
+    }
+    conn.Close()
+    delete(srv.compConnOut, cid)
+    delete(srv.compConnIn, cid)
+    delete(srv.published, cid)
+    namespace := srv.namespaceOf[cid]
+    identity, hasIdentity := srv.identityOf[cid]
+    if hasIdentity && srv.identities[namespaced(namespace, identity)] == cid {
+        delete(srv.identities, namespaced(namespace, identity))
+    }
+    delete(srv.identityOf, cid)
+    delete(srv.namespaceOf, cid)
+    delete(srv.lastSeen, cid)
+    delete(srv.beats, cid)
+    delete(srv.membershipSubs, cid)
+    pending := srv.pendingMids[cid]
+    delete(srv.pendingMids, cid)
+    waiting := srv.waitingReqs[namespace][:0]
+    for _, req := range srv.waitingReqs[namespace] {
+        if req.cid != cid {
+            waiting = append(waiting, req)
+        }
+    }
+    srv.waitingReqs[namespace] = waiting
+    empty := NewTuple()
+    for mid := range pending {
+        for _, other := range srv.peers(namespace) {
+            srv.sendToComponent(other, "DATA", itoa(mid), itoa(cid), False().String(), empty.encode())
+        }
+    }
+    srv.notifyMembership(MembershipEvent{ComponentLeft, cid, identity}, namespace, srv.membershipSubs)
+    if srv.prioritySequencing {
+        srv.grantWaiting(namespace)
+    }
+}
+
+// namespacedName is a name in a namespace (i.e. a tenant). Namespaces and
+// names can contain any character, hence they are not joined in a string.
+type namespacedName struct {
+    ns, id string
+}
+
+// namespaced returns the key of name in the namespace namespace.
+func namespaced(namespace string, name string) namespacedName {
+    return namespacedName{namespace, name}
+}
+
+// peers returns the components connected to namespace. It must be called
+// holding srv.lock.
+func (srv *CentralServer) peers(namespace string) []int {
+    cids := []int{}
+    for cid := range srv.compConnOut {
+        if srv.namespaceOf[cid] == namespace {
+            cids = append(cids, cid)
+        }
+    }
+    return cids
+}
+
+// newMid assigns the next message id of the total order of namespace. It must
+// be called holding srv.lock.
+func (srv *CentralServer) newMid(namespace string) int {
+    mid := srv.nextMsgIds[namespace]
+    srv.nextMsgIds[namespace] = mid + 1
+    return mid
+}
+
+// notifyMembership sequences a message carrying evt in namespace: the
+// components in to get it, the others get an empty message in its place. It
+// must be called holding srv.lock.
+func (srv *CentralServer) notifyMembership(evt MembershipEvent, namespace string, to map[int]struct{}) {
+    mid := srv.newMid(namespace)
+    msg := evt.tuple()
+    empty := NewTuple()
+    for _, cid := range srv.peers(namespace) {
+        if _, notified := to[cid]; notified && cid != evt.ComponentId {
+            srv.sendToComponent(cid, "DATA", itoa(mid), itoa(InfrastructureId), True().String(), msg.encode())
+        } else {
+            srv.sendToComponent(cid, "DATA", itoa(mid), itoa(InfrastructureId), False().String(), empty.encode())
+        }
+    }
+}
+
+// writeStatus answers a STATUS request about namespace (see
+// QueryCentralServer) on conn:
+//
+//     STATUS nextMid messagesExchanged
+//     COMP cid identity idleMsec beatMsec lag buffered pendingMid pendingAgeMsec...
+//     END
+//
+// where lag and buffered are taken at the last heartbeat, and beatMsec, lag and
+// buffered are -1 for the components that never sent one.
+func (srv *CentralServer) writeStatus(conn net.Conn, namespace string) {
+    srv.lock.Lock()
+    now := timeNow()
+    lines := []string{encodeLine("STATUS", itoa(srv.nextMsgIds[namespace]), itoa(srv.messagesExchanged))}
+    for _, cid := range srv.peers(namespace) {
+        tokens := []string{"COMP", itoa(cid), srv.identityOf[cid], itoa(msecSince(now, srv.lastSeen[cid]))}
+        if beat, beating := srv.beats[cid]; beating {
+            tokens = append(tokens, itoa(msecSince(now, beat.at)), itoa(beat.lag), itoa(beat.buffered))
+        } else {
+            tokens = append(tokens, "-1", "-1", "-1")
+        }
+        for mid, assigned := range srv.pendingMids[cid] {
+            tokens = append(tokens, itoa(mid), itoa(msecSince(now, assigned)))
+        }
+        lines = append(lines, encodeLine(tokens...))
+    }
+    srv.lock.Unlock()
+    lines = append(lines, encodeLine("END"))
+    fmt.Fprintf(conn, "%s\n", strings.Join(lines, "\n"))
+}
+
+func msecSince(now time.Time, t time.Time) int {
+    return int(now.Sub(t) / time.Millisecond)
 }
 
 func (srv *CentralServer) ListenConn(cid int, bconn *bufio.Reader) {
     for{
-        serverMsg, _ := bconn.ReadString('\n')
-        if serverMsg == "" {
+        serverMsg, err := readLine(bconn)
+        if err != nil {
+            if err == errLineTooLong {
+                srv.reportError(fmt.Errorf("component %d: %w", cid, err))
+            }
+            srv.lock.Lock()
+            srv.dropComponent(cid)
+            srv.lock.Unlock()
+            return
+        }
+        if serverMsg == "" || serverMsg == "\n" {
             continue
         }
         dprintln("Accept:",serverMsg)
-	    escTokens := strings.Split(serverMsg[:len(serverMsg)-1], " ")
-	    tokens := make([]string, len(escTokens))
-	    for i, escTok := range escTokens {
-		    tokens[i], _ = unescape(escTok, 0)
-	    }
-	    params := tokens[1:]
-	    srv.lock.Lock()
-	    srv.messagesExchanged++
-	    switch(tokens[0]) {
-	        case "DATA":
-				senderid := atoi(params[1])
-				for cid := range srv.compConnOut {
-					if senderid != cid {
-					    dprintln("Sending msg to",cid,params)
-						srv.sendToComponent(cid, append([]string{"DATA"}, params...)...)
-						dprintln("Sent msg to",cid,params, srv.nextMsgId)
-					} else {
-					    dprintln("Skipping msg to",cid,params)
-					}
-				}
-			case "REQ":
-				cid := atoi(params[0])
-				mid := srv.nextMsgId
-				srv.nextMsgId++
-				dprintln("Sending RPLY to",cid)
-				srv.sendToComponent(cid, "RPLY", itoa(mid))
-			
-		}
-		srv.lock.Unlock()
+        connected := true
+        // a malformed line is dropped, the component stays connected
+        catchPanic("the central server", srv.reportError, func() {
+            connected = srv.handleLine(cid, splitLine(serverMsg))
+        })
+        if !connected {
+            return
+        }
     }
 }
 
+// handleLine serves a line sent by the component cid, and returns false if cid
+// is not connected anymore. Lines with missing parameters are ignored, and so
+// is the cid they carry: a line always acts on behalf of the component bound
+// to the connection, which can only use the mids assigned to it.
+func (srv *CentralServer) handleLine(cid int, tokens []string) bool {
+	params := tokens[1:]
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	if _, connected := srv.compConnOut[cid]; !connected {
+	    // fenced: whatever it still sends is ignored
+	    return false
+	}
+	srv.lastSeen[cid] = timeNow()
+	namespace := srv.namespaceOf[cid]
+	srv.messagesExchanged++
+	switch(tokens[0]) {
+	    case "DATA": // DATA mid cid pred msg
+	        if len(params) < 4 {
+	            break
+	        }
+	        mid := atoi(params[0])
+	        if _, pending := srv.pendingMids[cid][mid]; !pending {
+	            // not a mid of this component
+	            break
+	        }
+			delete(srv.pendingMids[cid], mid)
+			params[1] = itoa(cid)
+			for _, other := range srv.peers(namespace) {
+				if other != cid {
+				    dprintln("Sending msg to",other,params)
+					srv.sendToComponent(other, append([]string{"DATA"}, params...)...)
+					dprintln("Sent msg to",other,params)
+				} else {
+				    dprintln("Skipping msg to",other,params)
+				}
+			}
+			if srv.prioritySequencing {
+			    srv.grantWaiting(namespace)
+			}
+		case "REQ": // REQ cid [priority]
+			if !srv.prioritySequencing {
+			    srv.assignMid(cid, namespace)
+			    break
+			}
+			priority := 0
+			if len(params) > 1 {
+			    priority = atoi(params[1])
+			}
+			srv.waitingReqs[namespace] = append(srv.waitingReqs[namespace], midRequest{cid, priority, srv.nextReqSeq})
+			srv.nextReqSeq++
+			srv.grantWaiting(namespace)
+		case "MEMBERS": // MEMBERS cid
+			srv.membershipSubs[cid] = struct{}{}
+			for _, other := range srv.peers(namespace) {
+			    if other != cid {
+			        srv.notifyMembership(MembershipEvent{ComponentJoined, other, srv.identityOf[other]}, namespace, map[int]struct{}{cid: struct{}{}})
+			    }
+			}
+		case "TIME": // TIME cid qid
+		    if len(params) < 2 {
+		        break
+		    }
+			srv.sendToComponent(cid, "TIMEIS", params[1], strconv.FormatInt(timeNow().UnixNano(), 10))
+		case "ATTRS": // ATTRS cid name value name value...
+			values := map[string]interface{}{}
+			for i := 1; i+1 < len(params); i += 2 {
+			    if val, valid := decodeTypedValue(params[i+1]); valid {
+			        values[params[i]] = val
+			    }
+			}
+			if _, has := srv.published[cid]; !has {
+			    srv.published[cid] = NewAttributes()
+			}
+			srv.published[cid].init(values)
+		case "COUNT": // COUNT cid qid pred
+		    if len(params) < 3 {
+		        break
+		    }
+			n := 0
+			if pred, err := ToPredicate(params[2]); err == nil {
+			    for other, attrs := range srv.published {
+			        if srv.namespaceOf[other] == namespace && pred.Satisfy(attrs) {
+			            n++
+			        }
+			    }
+			}
+			srv.sendToComponent(cid, "COUNTED", params[1], itoa(n))
+		case "BEAT": // BEAT cid maxMid buffered
+		    if len(params) < 3 {
+		        break
+		    }
+		    buffered := atoi(params[2])
+		    lag := srv.nextMsgIds[namespace] - 1 - atoi(params[1]) + buffered
+		    if lag < 0 {
+		        lag = 0
+		    }
+		    srv.beats[cid] = heartbeat{timeNow(), lag, buffered}
+	}
+	return true
+}
+
 func RunCentralServerLoop(port int) *CentralServer {
     return RunCentralServer(port, make(chan struct{}), 0)
 }
@@ -158,12 +586,21 @@ func RunCentralServerLoop(port int) *CentralServer {
 func RunCentralServer(port int, term chan struct{}, msec int64) *CentralServer {
 	srv := CentralServer{
 		nextCompId:           0,
-		nextMsgId:            0,
+		nextMsgIds:           map[string]int{},
 		//compAddresses:        map[int]string{},
 		messagesExchanged:    0,
 	    lock: &sync.Mutex{},
 	    compConnOut: map[int]net.Conn{},
 	    compConnIn: map[int]*bufio.Reader{},
+	    published: map[int]*Attributes{},
+	    pendingMids: map[int]map[int]time.Time{},
+	    lastSeen: map[int]time.Time{},
+	    beats: map[int]heartbeat{},
+	    identityOf: map[int]string{},
+	    namespaceOf: map[int]string{},
+	    membershipSubs: map[int]struct{}{},
+	    identities: map[namespacedName]int{},
+	    waitingReqs: map[string][]midRequest{},
 	}
 	var err error
 	srv.listener, err = net.Listen("tcp", ":"+itoa(port))
@@ -0,0 +1,94 @@
+package goat
+
+import (
+    "fmt"
+    "net"
+    "time"
+)
+
+/*
+CentralServerStatus is a snapshot of a running CentralServer, as returned by
+QueryCentralServer.
+*/
+type CentralServerStatus struct {
+    // NextMessageId is the next message id the server will assign.
+    NextMessageId int
+    MessagesExchanged int
+    Components []ComponentStatus
+}
+
+/*
+ComponentStatus describes a component connected to a CentralServer.
+*/
+type ComponentStatus struct {
+    Id int
+    // Identity is empty if the component registered without one.
+    Identity string
+    // Idle is the time since the component last sent something.
+    Idle time.Duration
+    // SinceHeartbeat is the time since the last heartbeat of the component,
+    // which its agent sends every second; -1 if it never sent one.
+    SinceHeartbeat time.Duration
+    // Lag is how many of the messages sequenced at the last heartbeat the
+    // component had not processed yet, -1 if unknown.
+    Lag int
+    // Buffered is how many of those the component had already received and
+    // kept in memory, waiting for its processes, -1 if unknown.
+    Buffered int
+    // PendingMids are the message ids assigned to the component and not used
+    // yet, with the time since they were assigned. Until they are used every
+    // component waits for them.
+    PendingMids map[int]time.Duration
+}
+
+/*
+QueryCentralServer returns the status of the CentralServer listening at
+address, "host:port" or "host:port/namespace" for the system of a tenant other
+than the default one.
+*/
+func QueryCentralServer(address string) (*CentralServerStatus, error) {
+    address, namespace := splitNamespace(address)
+    conn, err := net.Dial("tcp", address)
+    if err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrDisconnected, err)
+    }
+    dc := newDuplexConn(conn)
+    defer dc.Close()
+    if err := dc.Send("STATUS", namespace); err != nil {
+        return nil, fmt.Errorf("%w: %v", ErrDisconnected, err)
+    }
+    status := CentralServerStatus{}
+    for {
+        cmd, params, err := dc.ReceiveErr()
+        if err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrDisconnected, err)
+        }
+        if cmd == "STATUS" && len(params) < 2 || cmd == "COMP" && len(params) < 6 {
+            return nil, fmt.Errorf("goat: malformed status line %q", encodeLine(append([]string{cmd}, params...)...))
+        }
+        switch cmd {
+            case "STATUS":
+                status.NextMessageId = atoi(params[0])
+                status.MessagesExchanged = atoi(params[1])
+            case "COMP":
+                comp := ComponentStatus{
+                    Id: atoi(params[0]),
+                    Identity: params[1],
+                    Idle: time.Duration(atoi(params[2])) * time.Millisecond,
+                    SinceHeartbeat: -1,
+                    Lag: atoi(params[4]),
+                    Buffered: atoi(params[5]),
+                    PendingMids: map[int]time.Duration{},
+                }
+                if beat := atoi(params[3]); beat >= 0 {
+                    comp.SinceHeartbeat = time.Duration(beat) * time.Millisecond
+                }
+                for i := 6; i+1 < len(params); i += 2 {
+                    comp.PendingMids[atoi(params[i])] = time.Duration(atoi(params[i+1])) * time.Millisecond
+                }
+                status.Components = append(status.Components, comp)
+            case "END":
+                return &status, nil
+        }
+    }
+}
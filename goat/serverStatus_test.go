@@ -0,0 +1,54 @@
+package goat
+
+import (
+    "testing"
+    "time"
+)
+
+func TestQueryCentralServer(t *testing.T) {
+    srv := RunCentralServerLoop(17731)
+    send, lines := pipeComponent(srv, 100, "")
+    pipeComponent(srv, 101, "")
+    pipeComponent(srv, 102, "tenant")
+    // 7 messages received and not processed, none sequenced yet
+    send("BEAT", "100", "-1", "7")
+    send("REQ", "100")
+    mid := atoi(nextLine(t, lines, "RPLY")[1])
+
+    status, err := QueryCentralServer("127.0.0.1:17731")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if status.NextMessageId != mid + 1 || len(status.Components) != 2 {
+        t.Fatal("the default namespace has 2 components and a mid in use:", status)
+    }
+    for _, comp := range status.Components {
+        switch comp.Id {
+            case 100:
+                if _, pending := comp.PendingMids[mid]; !pending || comp.SinceHeartbeat < 0 || comp.Lag != 7 || comp.Buffered != 7 {
+                    t.Error("wrong status of the beating component:", comp)
+                }
+            case 101:
+                if len(comp.PendingMids) != 0 || comp.SinceHeartbeat != -1 || comp.Lag != -1 || comp.Buffered != -1 {
+                    t.Error("wrong status of the silent component:", comp)
+                }
+            default:
+                t.Error("a component of another namespace:", comp)
+        }
+    }
+    if status, err := QueryCentralServer("127.0.0.1:17731/tenant"); err != nil || len(status.Components) != 1 {
+        t.Error("the tenant has 1 component:", status, err)
+    }
+
+    c := NewComponent(NewSingleServerAgent("127.0.0.1:17731"), nil)
+    time.Sleep(heartbeatInterval + 200 * time.Millisecond)
+    status, err = QueryCentralServer("127.0.0.1:17731")
+    if err != nil {
+        t.Fatal(err)
+    }
+    for _, comp := range status.Components {
+        if comp.Id == c.GetAgent().GetComponentId() && (comp.SinceHeartbeat < 0 || comp.Lag != 0 || comp.Buffered != 0) {
+            t.Error("an idle agent is up to date:", comp)
+        }
+    }
+}
@@ -0,0 +1,389 @@
+package goat
+
+import (
+    "bufio"
+    "errors"
+    "fmt"
+    "net"
+    "strings"
+    "testing"
+    "time"
+)
+
+// testServers are the ports of the central servers started by the tests.
+var testServers = map[int]struct{}{}
+
+// testServer starts a central server on port, unless already started, and
+// returns its address.
+func testServer(port int) string {
+    if _, has := testServers[port]; !has {
+        testServers[port] = struct{}{}
+        RunCentralServerLoop(port)
+        time.Sleep(100 * time.Millisecond)
+    }
+    return "127.0.0.1:" + itoa(port)
+}
+
+// pipeComponent connects a fake component cid to srv through pipes: the
+// returned function sends a line to srv, and the lines srv sends to the
+// component are put on the returned channel.
+func pipeComponent(srv *CentralServer, cid int, namespace string) (func(tokens ...string), chan []string) {
+    srvIn, compOut := net.Pipe()
+    srvOut, compIn := net.Pipe()
+    srv.lock.Lock()
+    srv.compConnOut[cid] = srvOut
+    srv.namespaceOf[cid] = namespace
+    srv.lock.Unlock()
+    go srv.ListenConn(cid, bufio.NewReader(srvIn))
+    lines := make(chan []string, 10)
+    go func() {
+        reader := bufio.NewReader(compIn)
+        for {
+            line, err := reader.ReadString('\n')
+            if err != nil {
+                return
+            }
+            cmd, params := decodeLine(line[:len(line)-1])
+            lines <- append([]string{cmd}, params...)
+        }
+    }()
+    return func(tokens ...string) {
+        fmt.Fprintf(compOut, "%s\n", encodeLine(tokens...))
+    }, lines
+}
+
+// nextLine returns the next line with command cmd received on lines.
+func nextLine(t *testing.T, lines chan []string, cmd string) []string {
+    for {
+        select {
+            case line := <-lines:
+                if line[0] == cmd {
+                    return line
+                }
+            case <-time.After(5 * time.Second):
+                t.Fatal("no", cmd, "received")
+        }
+    }
+}
+
+func TestPrioritySequencing(t *testing.T) {
+    srv := RunCentralServerLoop(17726)
+    srv.SetPrioritySequencing(true)
+    send0, lines0 := pipeComponent(srv, 100, "")
+    send1, lines1 := pipeComponent(srv, 101, "")
+    send2, lines2 := pipeComponent(srv, 102, "")
+
+    empty := NewTuple()
+    send0("REQ", "100", "0")
+    mid := nextLine(t, lines0, "RPLY")[1]
+    // while mid is unused the other requests wait
+    send1("REQ", "101", "1")
+    send2("REQ", "102", "5")
+    time.Sleep(50 * time.Millisecond)
+    select {
+        case line := <-lines1:
+            t.Fatal("a mid was assigned while another one is unused", line)
+        default:
+    }
+    send0("DATA", mid, "100", False().String(), empty.encode())
+    next := nextLine(t, lines2, "RPLY")[1]
+    if atoi(next) != atoi(mid) + 1 {
+        t.Error("the send with the highest priority must get the next mid, got", next)
+    }
+    send2("DATA", next, "102", False().String(), empty.encode())
+    if last := nextLine(t, lines1, "RPLY")[1]; atoi(last) != atoi(next) + 1 {
+        t.Error("unexpected mid", last)
+    }
+}
+
+func TestMalformedLines(t *testing.T) {
+    srv := RunCentralServerLoop(17729)
+    errs := make(chan error, 10)
+    srv.SetErrorHandler(func(err error) {
+        errs <- err
+    })
+    // a registration without port, and an unknown command
+    for _, line := range []string{"Register", "HELLO"} {
+        conn, err := net.Dial("tcp", "127.0.0.1:17729")
+        if err != nil {
+            t.Fatal(err)
+        }
+        fmt.Fprintf(conn, "%s\n", line)
+        conn.Close()
+    }
+    send, lines := pipeComponent(srv, 100, "")
+    for _, cmd := range []string{"REQ", "DATA", "MEMBERS", "TIME", "ATTRS", "COUNT"} {
+        send(cmd)
+    }
+    send("DATA", "0", "100")
+    send("REQ", "100")
+    nextLine(t, lines, "RPLY")
+
+    c := NewComponent(NewSingleServerAgent("127.0.0.1:17729"), nil)
+    if c.GetAgent().GetComponentId() < 0 {
+        t.Error("the server stopped registering components")
+    }
+    select {
+        case err := <-errs:
+            t.Error("the malformed lines must be ignored:", err)
+        default:
+    }
+}
+
+func TestConnectionBoundIds(t *testing.T) {
+    srv := RunCentralServerLoop(17735)
+    send0, lines0 := pipeComponent(srv, 100, "a")
+    send1, lines1 := pipeComponent(srv, 101, "b")
+    send2, lines2 := pipeComponent(srv, 102, "a")
+    empty := NewTuple()
+
+    // 101 cannot act on behalf of 100, even less in another namespace
+    send1("ATTRS", "100", "role", "S|spoofed")
+    send1("TIME", "100", "7")
+    if line := nextLine(t, lines1, "TIMEIS"); line[1] != "7" {
+        t.Fatal(line)
+    }
+    send0("COUNT", "101", "8", Equals(Receiver("role"), "spoofed").CloseUnder(NewAttributes()).String())
+    if line := nextLine(t, lines0, "COUNTED"); line[1] != "8" || line[2] != "0" {
+        t.Error("the attributes published by 101 must not be those of 100:", line)
+    }
+
+    send0("REQ", "101")
+    mid := nextLine(t, lines0, "RPLY")[1]
+    // 102 cannot use the mid of 100
+    forged := NewTuple("forged")
+    send2("DATA", mid, "100", True().String(), forged.encode())
+    send0("DATA", mid, "999", True().String(), empty.encode())
+    if line := nextLine(t, lines2, "DATA"); line[1] != mid || line[2] != "100" || decodeTuple(line[4]).Length() != 0 {
+        t.Error("only 100 can use its mid, under its own id:", line)
+    }
+    select {
+        case line := <-lines1:
+            t.Error("a message leaked to another namespace:", line)
+        default:
+    }
+}
+
+func TestLineTooLong(t *testing.T) {
+    chunked := bufio.NewReaderSize(strings.NewReader(strings.Repeat("x", 100) + "\nnext\n"), 16)
+    if line, err := readLine(chunked); err != nil || len(line) != 101 {
+        t.Fatal("a line longer than the buffer must be read whole:", len(line), err)
+    }
+    if line, err := readLine(chunked); err != nil || line != "next\n" {
+        t.Fatal(line, err)
+    }
+    srv := RunCentralServerLoop(17748)
+    errs := make(chan error, 10)
+    srv.SetErrorHandler(func(err error) {
+        errs <- err
+    })
+    send, _ := pipeComponent(srv, 100, "")
+    // the writer stays blocked once the server stops reading
+    go send("DATA", "0", "100", True().String(), strings.Repeat("A", maxLineLength))
+    select {
+        case err := <-errs:
+            if !errors.Is(err, errLineTooLong) {
+                t.Fatal(err)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("the line too long was not reported")
+    }
+    srv.lock.Lock()
+    _, connected := srv.compConnOut[100]
+    srv.lock.Unlock()
+    if connected {
+        t.Error("the component sending a line too long must be dropped")
+    }
+}
+
+// registerComponent registers a fake component with srv, over TCP since the
+// server connects back to it, sending "Register <port> params...". It returns
+// the first line received, a function that sends lines to srv, and the
+// channel of the lines received, which is closed when srv disconnects it.
+func registerComponent(t *testing.T, address string, params ...string) ([]string, func(tokens ...string), chan []string, func()) {
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer listener.Close()
+    out, err := net.Dial("tcp", address)
+    if err != nil {
+        t.Fatal(err)
+    }
+    port := itoa(listener.Addr().(*net.TCPAddr).Port)
+    fmt.Fprintf(out, "%s\n", encodeLine(append([]string{"Register", port}, params...)...))
+    in, err := listener.Accept()
+    if err != nil {
+        t.Fatal(err)
+    }
+    lines := make(chan []string, 10)
+    go func() {
+        defer close(lines)
+        reader := bufio.NewReader(in)
+        for {
+            line, err := reader.ReadString('\n')
+            if err != nil {
+                return
+            }
+            cmd, params := decodeLine(line[:len(line)-1])
+            lines <- append([]string{cmd}, params...)
+        }
+    }()
+    var first []string
+    select {
+        case first = <-lines:
+        case <-time.After(5 * time.Second):
+            t.Fatal("no reply to Register", params)
+    }
+    send := func(tokens ...string) {
+        fmt.Fprintf(out, "%s\n", encodeLine(tokens...))
+    }
+    return first, send, lines, func() {
+        out.Close()
+        in.Close()
+    }
+}
+
+// closedWithin tells whether lines is closed within five seconds, skipping
+// the lines received before.
+func closedWithin(lines chan []string) bool {
+    for {
+        select {
+            case _, open := <-lines:
+                if !open {
+                    return true
+                }
+            case <-time.After(5 * time.Second):
+                return false
+        }
+    }
+}
+
+func TestRejectDuplicate(t *testing.T) {
+    srv := RunCentralServerLoop(17749)
+    srv.SetDuplicateIdentityPolicy(RejectDuplicate)
+    address := "127.0.0.1:17749"
+    first, send0, lines0, _ := registerComponent(t, address, "worker", "a")
+    if first[0] != "Registered" {
+        t.Fatal(first)
+    }
+    cid := first[1]
+    if first, _, lines, _ := registerComponent(t, address, "worker", "a"); first[0] != "Rejected" || first[1] != "worker" {
+        t.Error("the duplicate must be rejected:", first)
+    } else if !closedWithin(lines) {
+        t.Error("the connection of the rejected component must be closed")
+    }
+    // identities are per namespace
+    if first, _, _, _ := registerComponent(t, address, "worker", "b"); first[0] != "Registered" {
+        t.Error("the identity is free in another namespace:", first)
+    }
+    if first, _, _, _ := registerComponent(t, address, "x/worker", "a"); first[0] != "Registered" {
+        t.Fatal(first)
+    }
+    if first, _, _, _ := registerComponent(t, address, "worker", "a/x"); first[0] != "Registered" {
+        t.Error("the identity x/worker in a is not worker in a/x:", first)
+    }
+    // the holder is not affected
+    send0("TIME", cid, "1")
+    if line := nextLine(t, lines0, "TIMEIS"); line[1] != "1" {
+        t.Error(line)
+    }
+}
+
+func TestFenceDuplicate(t *testing.T) {
+    srv := RunCentralServerLoop(17750)
+    srv.SetDuplicateIdentityPolicy(FenceDuplicate)
+    address := "127.0.0.1:17750"
+    old, _, oldLines, _ := registerComponent(t, address, "worker", "a")
+    if old[0] != "Registered" {
+        t.Fatal(old)
+    }
+    _, _, witness, _ := registerComponent(t, address, "", "a")
+    first, send, lines, _ := registerComponent(t, address, "worker", "a")
+    if first[0] != "Registered" || first[1] == old[1] {
+        t.Fatal("the newcomer must be registered:", first)
+    }
+    if line := nextLine(t, oldLines, "Fenced"); line[1] != "worker" {
+        t.Error(line)
+    }
+    if !closedWithin(oldLines) {
+        t.Fatal("the connection of the fenced component must be closed")
+    }
+    srv.lock.Lock()
+    holder := srv.identities[namespaced("a", "worker")]
+    _, connected := srv.compConnOut[atoi(old[1])]
+    srv.lock.Unlock()
+    if itoa(holder) != first[1] || connected {
+        t.Error("the identity must pass to the newcomer, and the old holder be dropped")
+    }
+    // the old holder does not receive the messages of the namespace anymore
+    send("REQ", first[1])
+    mid := nextLine(t, lines, "RPLY")[1]
+    after := NewTuple("after")
+    send("DATA", mid, first[1], True().String(), after.encode())
+    // skipping the fillers of the mids left by the fenced component
+    for line := nextLine(t, witness, "DATA"); line[1] != mid; line = nextLine(t, witness, "DATA") {
+    }
+    select {
+        case line, open := <-oldLines:
+            if open {
+                t.Error("the fenced component received", line)
+            }
+        case <-time.After(100 * time.Millisecond):
+    }
+}
+
+func TestIdentityFreedOnDisconnect(t *testing.T) {
+    srv := RunCentralServerLoop(17751)
+    srv.SetDuplicateIdentityPolicy(RejectDuplicate)
+    address := "127.0.0.1:17751"
+    first, _, _, disconnect := registerComponent(t, address, "worker")
+    if first[0] != "Registered" {
+        t.Fatal(first)
+    }
+    disconnect()
+    for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+        srv.lock.Lock()
+        _, taken := srv.identities[namespaced("", "worker")]
+        srv.lock.Unlock()
+        if !taken {
+            break
+        } else if time.Since(start) > 5 * time.Second {
+            t.Fatal("the identity of a disconnected component must be freed")
+        }
+    }
+    if first, _, _, _ := registerComponent(t, address, "worker"); first[0] != "Registered" {
+        t.Error("the identity of a disconnected component must be reusable:", first)
+    }
+}
+
+func TestAgentIdentity(t *testing.T) {
+    srv := RunCentralServerLoop(17752)
+    srv.SetDuplicateIdentityPolicy(RejectDuplicate)
+    agent := NewSingleServerAgent("127.0.0.1:17752/tenant")
+    agent.SetIdentity("worker 1")
+    NewComponent(agent, nil)
+    srv.lock.Lock()
+    identity := srv.identityOf[agent.GetComponentId()]
+    holder, taken := srv.identities[namespaced("tenant", "worker 1")]
+    srv.lock.Unlock()
+    if identity != "worker 1" || !taken || holder != agent.GetComponentId() {
+        t.Fatal("the agent must register with its identity:", identity, holder, taken)
+    }
+    duplicate := NewSingleServerAgent("127.0.0.1:17752/tenant")
+    duplicate.SetIdentity("worker 1")
+    d := NewComponent(duplicate, nil)
+    select {
+        case err := <-d.Errors():
+            if !errors.Is(err, ErrDuplicateIdentity) {
+                t.Error(err)
+            }
+        case <-time.After(5 * time.Second):
+            t.Error("the duplicate was not rejected")
+    }
+    select {
+        case <-d.Stopped():
+        case <-time.After(5 * time.Second):
+            t.Error("the rejected component must stop")
+    }
+}
@@ -0,0 +1,50 @@
+package goat
+
+import (
+    "testing"
+    "time"
+)
+
+func TestSpawnComponent(t *testing.T) {
+    // in a namespace, which the sibling must share
+    parent := NewComponent(NewSingleServerAgent(testServer(17742) + "/family"), map[string]interface{}{"role": "parent"})
+    results := make(chan interface{}, 4)
+    parent.Start(func(p *Process) {
+        child, err := p.SpawnComponent(map[string]interface{}{"role": "child"}, func(q *Process) {
+            msg := q.Receive(func(attr *Attributes, msg Tuple) bool {
+                return msg.Get(0) == "ping"
+            })
+            q.Send(NewTuple("pong", msg.Get(1)), Equals(Receiver("role"), "parent"))
+        })
+        if err != nil {
+            t.Error(err)
+            return
+        }
+        results <- child
+        p.Send(NewTuple("ping", 7), Equals(Receiver("role"), "child"))
+        results <- p.Receive(func(attr *Attributes, msg Tuple) bool {
+            return msg.Get(0) == "pong"
+        })
+    })
+    var child *Component
+    select {
+        case c := <-results:
+            child = c.(*Component)
+        case <-time.After(5 * time.Second):
+            t.Fatal("the child was not spawned")
+    }
+    if child.agent.GetComponentId() == parent.agent.GetComponentId() {
+        t.Error("the child must register as a new component")
+    }
+    if ns := child.agent.(*SingleServerAgent).namespace; ns != "family" {
+        t.Error("the child must join the namespace of the parent, not", ns)
+    }
+    select {
+        case reply := <-results:
+            if reply.(Tuple).Get(1) != 7 {
+                t.Error(reply)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatal("parent and child did not exchange messages")
+    }
+}
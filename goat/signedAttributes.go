@@ -0,0 +1,226 @@
+package goat
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/gob"
+    "encoding/hex"
+    "fmt"
+    "hash"
+    "sort"
+    "sync"
+    "time"
+)
+
+func init() {
+    gob.Register(SignedAttributes{})
+}
+
+/*
+SignedAttributes travels as the last element of the messages sent by
+SignedSend: it carries the values of some attributes of the sender, and a MAC
+binding them to the rest of the message, to its predicate, to the time it was
+signed and to a random nonce.
+*/
+type SignedAttributes struct {
+    Names []string
+    Values []string
+    Predicate string
+    Time int64
+    Nonce string
+    MAC []byte
+}
+
+// signatureMaxAge is the default of AttributeSigner.SetMaxAge.
+const signatureMaxAge = 30 * time.Second
+
+/*
+AttributeSigner signs and verifies sender attributes with a key shared by the
+trusted components. Only attributes of type string, int, bool or Tuple can be
+signed. To detect replays it remembers the nonces of the messages it verified
+for as long as they are fresh, hence it can be shared by the components of a
+host but it should not be shared by components in different namespaces.
+*/
+type AttributeSigner struct {
+    key []byte
+    maxAge time.Duration
+    // now is the clock of the signatures, timeNow but in tests
+    now func() time.Time
+    lock *sync.Mutex
+    // seen maps the nonces verified so far to the message that carried them
+    seen map[string]seenNonce
+}
+
+type seenNonce struct {
+    mid int
+    time int64
+}
+
+func NewAttributeSigner(key []byte) *AttributeSigner {
+    return &AttributeSigner{
+        key: append([]byte{}, key...),
+        maxAge: signatureMaxAge,
+        now: timeNow,
+        lock: &sync.Mutex{},
+        seen: map[string]seenNonce{},
+    }
+}
+
+/*
+SetMaxAge sets how old (or how far in the future, to allow for clock skew) a
+signature can be to be accepted, 30 seconds by default.
+*/
+func (as *AttributeSigner) SetMaxAge(maxAge time.Duration) {
+    as.lock.Lock()
+    as.maxAge = maxAge
+    as.lock.Unlock()
+}
+
+func (as *AttributeSigner) mac(signed SignedAttributes, body Tuple) []byte {
+    h := hmac.New(sha256.New, as.key)
+    writeCanonical(h, signed.Predicate)
+    writeCanonical(h, signed.Time)
+    writeCanonical(h, signed.Nonce)
+    fmt.Fprintf(h, "N%d:", len(signed.Names))
+    for i := range signed.Names {
+        writeCanonical(h, signed.Names[i])
+        writeCanonical(h, signed.Values[i])
+    }
+    writeCanonical(h, body)
+    return h.Sum(nil)
+}
+
+// writeCanonical writes x to h so that equal values are always written the
+// same way and different values differently; unlike gob it does not depend on
+// the order in which maps are visited.
+func writeCanonical(h hash.Hash, x interface{}) {
+    switch val := x.(type) {
+        case Tuple:
+            fmt.Fprintf(h, "T%d:", val.Length())
+            for _, elem := range val.Elems {
+                writeCanonical(h, elem)
+            }
+        case string:
+            fmt.Fprintf(h, "S%d:%s", len(val), val)
+        default:
+            // fmt prints the keys of maps sorted
+            s := fmt.Sprintf("%T:%#v", val, val)
+            fmt.Fprintf(h, "V%d:%s", len(s), s)
+    }
+}
+
+func (as *AttributeSigner) sign(attr *Attributes, names []string, body Tuple, pred ClosedPredicate) SignedAttributes {
+    sorted := append([]string{}, names...)
+    sort.Strings(sorted)
+    values := make([]string, len(sorted))
+    for i, name := range sorted {
+        values[i] = encodeTypedValue(attr.GetValue(name))
+    }
+    nonce := make([]byte, 16)
+    if _, err := rand.Read(nonce); err != nil {
+        panic(err)
+    }
+    signed := SignedAttributes{
+        Names: sorted,
+        Values: values,
+        Predicate: pred.String(),
+        Time: as.now().UnixNano(),
+        Nonce: hex.EncodeToString(nonce),
+    }
+    signed.MAC = as.mac(signed, body)
+    return signed
+}
+
+/*
+Verify checks the signature carried by msg: it must be valid for the message
+and its predicate, fresh, and not already seen on another message. If it is, it
+returns the message without the signature, the signed attributes of the sender
+and true.
+*/
+func (as *AttributeSigner) Verify(msg Message) (Tuple, map[string]interface{}, bool) {
+    body := msg.Message
+    if body.Length() == 0 {
+        return body, nil, false
+    }
+    signed, isSigned := body.Get(body.Length() - 1).(SignedAttributes)
+    if !isSigned || len(signed.Names) != len(signed.Values) {
+        return body, nil, false
+    }
+    body = NewTuple(body.Elems[:body.Length() - 1]...)
+    if msg.Pred == nil || signed.Predicate != msg.Pred.String() ||
+        !hmac.Equal(signed.MAC, as.mac(signed, body)) {
+        return msg.Message, nil, false
+    }
+    if !as.fresh(signed, msg.Id) {
+        return msg.Message, nil, false
+    }
+    sender := map[string]interface{}{}
+    for i, name := range signed.Names {
+        val, valid := decodeTypedValue(signed.Values[i])
+        if !valid {
+            return msg.Message, nil, false
+        }
+        sender[name] = val
+    }
+    return body, sender, true
+}
+
+// fresh tells whether signed is recent and its nonce was not seen on a message
+// other than mid, and remembers the nonce.
+func (as *AttributeSigner) fresh(signed SignedAttributes, mid int) bool {
+    as.lock.Lock()
+    defer as.lock.Unlock()
+    now := as.now().UnixNano()
+    age := time.Duration(now - signed.Time)
+    if age > as.maxAge || age < -as.maxAge {
+        return false
+    }
+    for nonce, seen := range as.seen {
+        if time.Duration(now - seen.time) > as.maxAge {
+            delete(as.seen, nonce)
+        }
+    }
+    if seen, has := as.seen[signed.Nonce]; has {
+        return seen.mid == mid
+    }
+    as.seen[signed.Nonce] = seenNonce{mid, signed.Time}
+    return true
+}
+
+/*
+SignedSend behaves like Send, but appends to msg the values of the attributes
+names of the component, signed by signer together with the predicate.
+*/
+func (p *Process) SignedSend(signer *AttributeSigner, names []string, msg Tuple, pr Predicate) {
+    p.sendrec(func(attr *Attributes, receiving bool) SendReceive {
+        if receiving {
+            return ThenFail()
+        }
+        body := msg.CloseUnder(attr)
+        pred := pr.CloseUnder(attr)
+        signed := NewTuple(append(append([]interface{}{}, body.Elems...), signer.sign(attr, names, body, pred))...)
+        return ThenSend(signed, pred)
+    }, false)
+}
+
+/*
+VerifiedReceive behaves like Receive, but only accepts messages whose attached
+sender attributes are correctly signed by signer (see Verify). accept is given
+the message without the signature and the verified attributes of the sender.
+*/
+func (p *Process) VerifiedReceive(signer *AttributeSigner, accept func(attr *Attributes, msg Tuple, sender map[string]interface{}) bool) (Tuple, map[string]interface{}) {
+    var body Tuple
+    var sender map[string]interface{}
+    p.sendrec(func(attr *Attributes, receiving bool) SendReceive {
+        if !receiving {
+            return ThenFail()
+        }
+        return thenReceiveMessage(func(attr *Attributes, msg Message) bool {
+            var verified bool
+            body, sender, verified = signer.Verify(msg)
+            return verified && accept(attr, body, sender)
+        })
+    }, true)
+    return body, sender
+}
@@ -0,0 +1,78 @@
+package goat
+
+import (
+    "encoding/gob"
+    "testing"
+    "time"
+)
+
+func TestSignedAttributes(t *testing.T) {
+    attr := Attributes{}
+    attr.init(map[string]interface{}{"role": "admin", "level": 3})
+    signer := NewAttributeSigner([]byte("secret"))
+    body := NewTuple("shutdown", 5)
+    pred := Equals(Receiver("role"), "worker").CloseUnder(&attr)
+    msg := NewTuple("shutdown", 5, signer.sign(&attr, []string{"role", "level"}, body, pred))
+    msg = decodeTuple(msg.encode())
+    // the predicate travels as a string, like the message
+    wirePred, err := ToPredicate(pred.String())
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    got, sender, ok := signer.Verify(Message{Id: 10, Message: msg, Pred: wirePred})
+    if !ok || got.Length() != 2 || sender["role"] != "admin" || sender["level"] != 3 {
+        t.Fatal("a valid signature must be accepted", got, sender)
+    }
+    if _, _, ok := signer.Verify(Message{Id: 10, Message: msg, Pred: wirePred}); !ok {
+        t.Fatal("the same message can be verified again, e.g. by another process")
+    }
+    if _, _, ok := signer.Verify(Message{Id: 11, Message: msg, Pred: wirePred}); ok {
+        t.Fatal("a replayed message must be rejected")
+    }
+    if _, _, ok := NewAttributeSigner([]byte("other")).Verify(Message{Id: 10, Message: msg, Pred: wirePred}); ok {
+        t.Fatal("a signature with another key must be rejected")
+    }
+    forged := NewTuple("shutdown", 6, msg.Get(2))
+    if _, _, ok := NewAttributeSigner([]byte("secret")).Verify(Message{Id: 10, Message: forged, Pred: wirePred}); ok {
+        t.Fatal("a signature for another message must be rejected")
+    }
+    if _, _, ok := NewAttributeSigner([]byte("secret")).Verify(Message{Id: 10, Message: msg, Pred: True()}); ok {
+        t.Fatal("a signature for another predicate must be rejected")
+    }
+    spoofed := msg.Get(2).(SignedAttributes)
+    spoofed.Values = []string{"I|3", "S|root"}
+    if _, _, ok := signer.Verify(Message{Id: 12, Message: NewTuple("shutdown", 5, spoofed), Pred: wirePred}); ok {
+        t.Fatal("spoofed attributes must be rejected")
+    }
+    if _, _, ok := signer.Verify(Message{Id: 13, Message: body, Pred: wirePred}); ok {
+        t.Fatal("unsigned messages must be rejected")
+    }
+
+    late := NewAttributeSigner([]byte("secret"))
+    late.now = func() time.Time {
+        return time.Now().Add(time.Minute)
+    }
+    if _, _, ok := late.Verify(Message{Id: 10, Message: msg, Pred: wirePred}); ok {
+        t.Fatal("stale signatures must be rejected")
+    }
+}
+
+func TestSignedAttributesCanonical(t *testing.T) {
+    attr := Attributes{}
+    attr.init(map[string]interface{}{"role": "admin"})
+    signer := NewAttributeSigner([]byte("secret"))
+    gob.Register(map[string]int{})
+    values := map[string]int{}
+    for i := 0; i < 20; i++ {
+        values[itoa(i)] = i
+    }
+    body := NewTuple("config", values)
+    msg := NewTuple("config", values, signer.sign(&attr, []string{"role"}, body, True()))
+    // gob does not encode the entries of a map always in the same order
+    for i := 0; i < 10; i++ {
+        if _, _, ok := signer.Verify(Message{Id: 1, Message: decodeTuple(msg.encode()), Pred: True()}); !ok {
+            t.Fatal("the signature depends on the encoding of the message")
+        }
+    }
+}
@@ -3,30 +3,59 @@ package goat
 import(
     "net"
     "fmt"
+    "strconv"
     "strings"
     "bufio"
+    "time"
+    "sync"
 )
 
 type SingleServerAgent struct{
     componentId int
     firstMessageId int
     server string
+    serverAddress string
+    namespace string
+    identity string
     listeningPort int
     listener net.Listener
     chnMids *unboundChanInt
     chnMessagesIn *unboundChanMessage
     chnMessagesOut chan Message
     chnGetMid *unboundChanUnit
+    chnGetMidPriority *unboundChanInt
     inStrings *unboundChanString
+    chnCensusOut chan []string
+    lockCensus *sync.Mutex
+    nextQueryId int
+    queries map[int]chan int
+    timeQueries map[int]chan int64
+    maxMid int
+    lockMaxMid *sync.Mutex
+    onError func(error)
+    chnClosed chan struct{}
+    closeOnce *sync.Once
+    chnDisconnected chan struct{}
     
     serverOutConn net.Conn
     serverInConn *bufio.Reader
+    serverInRaw net.Conn
+    lockConns *sync.Mutex
 }
 
 
+/*
+NewSingleServerAgent returns an agent for the CentralServer at serverAddress,
+i.e. "host:port" or "host:port/namespace" to join the system of a tenant
+other than the default one.
+*/
 func NewSingleServerAgent(serverAddress string) *SingleServerAgent{
+    address, namespace := splitNamespace(serverAddress)
     ssa := SingleServerAgent{
+        serverAddress: address,
+        namespace: namespace,
         chnGetMid: newUnboundChanUnit(),
+        chnGetMidPriority: newUnboundChanInt(),
         chnMids: newUnboundChanInt(),
         //chnOutbox: make(chan Message, 5),
         //chnInbox: make(chan Message, 5),
@@ -36,24 +65,86 @@ func NewSingleServerAgent(serverAddress string) *SingleServerAgent{
         chnMessagesIn: newUnboundChanMessage(),
         chnMessagesOut: make(chan Message),
         inStrings: newUnboundChanString(),
+        chnCensusOut: make(chan []string),
+        lockCensus: &sync.Mutex{},
+        queries: map[int]chan int{},
+        timeQueries: map[int]chan int64{},
+        maxMid: -1,
+        lockMaxMid: &sync.Mutex{},
+        chnClosed: make(chan struct{}),
+        closeOnce: &sync.Once{},
+        chnDisconnected: make(chan struct{}),
+        lockConns: &sync.Mutex{},
     }
     
     return &ssa
 }
 
+/*
+Start connects the agent to the central server and registers the component. If
+the server cannot be reached, ErrDisconnected is reported and Start returns
+with the agent disconnected.
+*/
 func (ssa *SingleServerAgent) Start(){
-    ssa.listener, _ = net.Listen("tcp", ":0")
+    listener, err := net.Listen("tcp", ":0")
+    if err != nil {
+        ssa.reportError(fmt.Errorf("%w: %v", ErrDisconnected, err))
+        close(ssa.chnDisconnected)
+        return
+    }
+    ssa.lockConns.Lock()
+    ssa.listener = listener
+    ssa.lockConns.Unlock()
     myAddressPort := ssa.listener.Addr().String()
     portIndex := strings.LastIndex(myAddressPort, ":")
     ssa.listeningPort = atoi(myAddressPort[portIndex+1:])
     
     chnRegistered := make(chan bool, 1)
     
-    go func(){ssa.doIncomingProcess(chnRegistered)}()
-    go func(){ssa.doOutcomingProcess()}()
+    go func(){
+        defer recoverTo("the agent", ssa.onError)
+        ssa.doIncomingProcess(chnRegistered)
+    }()
+    go func(){
+        defer recoverTo("the agent", ssa.onError)
+        ssa.doOutcomingProcess()
+    }()
     <- chnRegistered
 }
 
+func (ssa *SingleServerAgent) SetErrorHandler(handler func(error)) {
+    ssa.onError = handler
+}
+
+func (ssa *SingleServerAgent) reportError(err error) {
+    if ssa.onError != nil {
+        ssa.onError(err)
+    }
+}
+
+/*
+SetIdentity sets the persistent identity the agent registers with, so that the
+central server can detect two sessions of the same component (see
+CentralServer.SetDuplicateIdentityPolicy). It must be called before the
+component is created.
+*/
+func (ssa *SingleServerAgent) SetIdentity(identity string) {
+    ssa.identity = identity
+}
+
+// NewSibling returns a new agent for the same central server.
+func (ssa *SingleServerAgent) NewSibling() Agent {
+    return NewSingleServerAgent(ssa.server)
+}
+
+// splitNamespace splits "host:port/namespace" in "host:port" and "namespace".
+func splitNamespace(address string) (string, string) {
+    if slash := strings.Index(address, "/"); slash >= 0 {
+        return address[:slash], address[slash+1:]
+    }
+    return address, ""
+}
+
 func (ssa *SingleServerAgent) GetComponentId() int{
     return ssa.componentId
 }
@@ -91,17 +182,47 @@ func (ssa *SingleServerAgent) doIncomingProcess(chnRegistered chan<- bool) {
             }
         }
     }()*/
-    conn, _ := ssa.listener.Accept()
+    // pending queries fail as soon as the server is gone
+    defer close(ssa.chnDisconnected)
+    // Start waits for the registration, unless the agent is disconnected first
+    registered := false
+    defer func() {
+        if !registered {
+            close(chnRegistered)
+        }
+    }()
+    conn, err := ssa.listener.Accept()
+    if err != nil {
+        // closed before the server connected
+        return
+    }
+    ssa.lockConns.Lock()
+    ssa.serverInRaw = conn
+    ssa.lockConns.Unlock()
+    if ssa.isClosed() {
+        conn.Close()
+        return
+    }
     ssa.serverInConn = bufio.NewReader(conn)
     for {
         dprintln(ssa.componentId,"IP+")
         cmd, params := ssa.receiveFromServer()
         dprintln(ssa.componentId,"IP-")
         switch cmd {
+            case "":
+                // disconnected
+                return
             case "Registered":
                 ssa.componentId = atoi(params[0])
                 ssa.firstMessageId = atoi(params[1])
+                registered = true
                 close(chnRegistered)
+            case "Rejected":
+                ssa.reportError(fmt.Errorf("%w: %s is already registered", ErrDuplicateIdentity, params[0]))
+                return
+            case "Fenced":
+                ssa.reportError(fmt.Errorf("%w: %s has registered again", ErrDuplicateIdentity, params[0]))
+                return
             case "RPLY":
                 mid := atoi(params[0])
                 dprintln(itoa(ssa.componentId), "got MID",mid)
@@ -110,31 +231,76 @@ func (ssa *SingleServerAgent) doIncomingProcess(chnRegistered chan<- bool) {
                 dprintln(ssa.componentId,"M-")
                 
             case "DATA":
-                pred, _ := ToPredicate(params[2])
+                pred := decodePredicate(params[2], ssa.reportError)
                 mid := atoi(params[0])
-                //cid := atoi(params[1])
                 inMsg := Message {
                     Id: mid,
-                    //componentId: cid,
                     Pred: pred,
                     Message: decodeTuple(params[3]),
+                    Sender: atoi(params[1]),
                 }
                 dprintln("<-", mid)
+                ssa.updateMaxMid(mid)
                 dprintln(ssa.componentId,"D+")
                 ssa.chnMessagesIn.In <- inMsg
                 dprintln(ssa.componentId,"D-")
+            case "COUNTED":
+                qid := atoi(params[0])
+                ssa.lockCensus.Lock()
+                chnReply, waiting := ssa.queries[qid]
+                delete(ssa.queries, qid)
+                ssa.lockCensus.Unlock()
+                if waiting {
+                    chnReply <- atoi(params[1])
+                }
+            case "TIMEIS":
+                qid := atoi(params[0])
+                nanos, _ := strconv.ParseInt(params[1], 10, 64)
+                ssa.lockCensus.Lock()
+                chnReply, waiting := ssa.timeQueries[qid]
+                delete(ssa.timeQueries, qid)
+                ssa.lockCensus.Unlock()
+                if waiting {
+                    chnReply <- nanos
+                }
         }
     }
 }
 
+// heartbeatInterval is how often the agent tells the central server it is
+// alive, and how far it got in the total order (see ComponentStatus).
+const heartbeatInterval = time.Second
+
 func (ssa *SingleServerAgent) doOutcomingProcess() {
     //dprintln("Try dialing:", escTokens)
-    conn, _ := net.Dial("tcp", ssa.server)
+    conn, err := net.Dial("tcp", ssa.serverAddress)
+    if err != nil {
+        if !ssa.isClosed() {
+            ssa.reportError(fmt.Errorf("%w: %v", ErrDisconnected, err))
+        }
+        // the server will not connect back: the incoming process gives up
+        ssa.listener.Close()
+        return
+    }
+    ssa.lockConns.Lock()
     ssa.serverOutConn = conn
+    ssa.lockConns.Unlock()
+    if ssa.isClosed() {
+        conn.Close()
+        return
+    }
     //Register
-    ssa.sendToServer("Register", itoa(ssa.listeningPort))
+    if ssa.namespace != "" {
+        ssa.sendToServer("Register", itoa(ssa.listeningPort), ssa.identity, ssa.namespace)
+    } else if ssa.identity != "" {
+        ssa.sendToServer("Register", itoa(ssa.listeningPort), ssa.identity)
+    } else {
+        ssa.sendToServer("Register", itoa(ssa.listeningPort))
+    }
 
     //Work
+    heartbeat := time.NewTicker(heartbeatInterval)
+    defer heartbeat.Stop()
     for {
         dprintln("Ready!")
         select {
@@ -145,6 +311,20 @@ func (ssa *SingleServerAgent) doOutcomingProcess() {
             case <- ssa.chnGetMid.Out:
                 dprintln(itoa(ssa.componentId), "asking for MID")
                 ssa.sendToServer("REQ", itoa(ssa.componentId))
+            case priority := <- ssa.chnGetMidPriority.Out:
+                ssa.sendToServer("REQ", itoa(ssa.componentId), itoa(priority))
+            case tokens := <- ssa.chnCensusOut:
+                ssa.sendToServer(tokens...)
+            case <- heartbeat.C:
+                // BEAT cid maxMid buffered, where the mids before the first
+                // one are not for this component
+                maxMid := ssa.GetMaxMid()
+                if maxMid < ssa.firstMessageId - 1 {
+                    maxMid = ssa.firstMessageId - 1
+                }
+                ssa.sendToServer("BEAT", itoa(ssa.componentId), itoa(maxMid), itoa(ssa.chnMessagesIn.Len()))
+            case <- ssa.chnClosed:
+                return
         }
     }
 }
@@ -164,20 +344,156 @@ func (ssa *SingleServerAgent) sendToServer(tokens... string) {
     conn, err := net.Dial("tcp", ssa.server)*/
     dprintln("Try:", escTokens)
     if n, err := fmt.Fprintf(ssa.serverOutConn, "%s\n", strings.Join(escTokens," ")); err != nil{
-        panic(err)
+        if ssa.isClosed() {
+            return
+        }
+        ssa.reportError(fmt.Errorf("%w: %v", ErrDisconnected, err))
     } else {
         dprintln("Conn:",n)
     }
 }   
 
 func (ssa *SingleServerAgent) SendMessage(msg Message) {
-    ssa.chnMessagesOut <- msg
+    select {
+        case ssa.chnMessagesOut <- msg:
+            ssa.updateMaxMid(msg.Id)
+        case <- ssa.chnClosed:
+    }
+}
+
+/*
+Close disconnects the agent from the central server, which then forgets the
+component and fills the message ids still assigned to it.
+*/
+func (ssa *SingleServerAgent) Close() {
+    ssa.closeOnce.Do(func() {
+        close(ssa.chnClosed)
+        ssa.lockConns.Lock()
+        defer ssa.lockConns.Unlock()
+        if ssa.serverOutConn != nil {
+            ssa.serverOutConn.Close()
+        }
+        if ssa.serverInRaw != nil {
+            ssa.serverInRaw.Close()
+        }
+        if ssa.listener != nil {
+            ssa.listener.Close()
+        }
+    })
+}
+
+// isClosed tells whether Close has been called.
+func (ssa *SingleServerAgent) isClosed() bool {
+    select {
+        case <- ssa.chnClosed:
+            return true
+        default:
+            return false
+    }
+}
+
+func (ssa *SingleServerAgent) updateMaxMid(mid int) {
+    ssa.lockMaxMid.Lock()
+    if mid > ssa.maxMid {
+        ssa.maxMid = mid
+    }
+    ssa.lockMaxMid.Unlock()
 }
 
 func (ssa *SingleServerAgent) AskMid(){
     ssa.chnGetMid.In <- struct{}{}
 }
 
+// AskMidPriority asks a mid for a send with the given priority.
+func (ssa *SingleServerAgent) AskMidPriority(priority int){
+    ssa.chnGetMidPriority.In <- priority
+}
+
+func (ssa *SingleServerAgent) PublishAttributes(attrs map[string]interface{}) {
+    tokens := []string{"ATTRS", itoa(ssa.componentId)}
+    for name, val := range attrs {
+        if enc := encodeTypedValue(val); enc != "X" {
+            tokens = append(tokens, name, enc)
+        }
+    }
+    ssa.sendCensus(tokens)
+}
+
+func (ssa *SingleServerAgent) SubscribeMembership() {
+    ssa.chnCensusOut <- []string{"MEMBERS", itoa(ssa.componentId)}
+}
+
+// queryTimeout is how long the agent waits for the answer to a query.
+const queryTimeout = 5 * time.Second
+
+// sendCensus sends tokens to the server, unless the agent is closed or
+// disconnected; it returns false if it did not.
+func (ssa *SingleServerAgent) sendCensus(tokens []string) bool {
+    select {
+        case ssa.chnCensusOut <- tokens:
+            return true
+        case <- ssa.chnClosed:
+        case <- ssa.chnDisconnected:
+    }
+    return false
+}
+
+func (ssa *SingleServerAgent) Count(pred ClosedPredicate) (int, error) {
+    chnReply := make(chan int, 1)
+    ssa.lockCensus.Lock()
+    qid := ssa.nextQueryId
+    ssa.nextQueryId++
+    ssa.queries[qid] = chnReply
+    ssa.lockCensus.Unlock()
+    forget := func() {
+        ssa.lockCensus.Lock()
+        delete(ssa.queries, qid)
+        ssa.lockCensus.Unlock()
+    }
+    if !ssa.sendCensus([]string{"COUNT", itoa(ssa.componentId), itoa(qid), pred.String()}) {
+        forget()
+        return 0, ErrDisconnected
+    }
+    select {
+        case n := <- chnReply:
+            return n, nil
+        case <- ssa.chnDisconnected:
+            forget()
+            return 0, ErrDisconnected
+        case <- time.After(queryTimeout):
+            forget()
+            return 0, fmt.Errorf("%w: COUNT %s", ErrQueryTimeout, pred.String())
+    }
+}
+
+func (ssa *SingleServerAgent) ServerTime() (time.Time, error) {
+    chnReply := make(chan int64, 1)
+    ssa.lockCensus.Lock()
+    qid := ssa.nextQueryId
+    ssa.nextQueryId++
+    ssa.timeQueries[qid] = chnReply
+    ssa.lockCensus.Unlock()
+    forget := func() {
+        ssa.lockCensus.Lock()
+        delete(ssa.timeQueries, qid)
+        ssa.lockCensus.Unlock()
+    }
+    if !ssa.sendCensus([]string{"TIME", itoa(ssa.componentId), itoa(qid)}) {
+        forget()
+        return time.Time{}, ErrDisconnected
+    }
+    select {
+        case nanos := <- chnReply:
+            return time.Unix(0, nanos), nil
+        case <- ssa.chnDisconnected:
+            forget()
+            return time.Time{}, ErrDisconnected
+        case <- time.After(queryTimeout):
+            forget()
+            return time.Time{}, fmt.Errorf("%w: TIME", ErrQueryTimeout)
+    }
+}
+
 func (ssa *SingleServerAgent) GetRplyChan() *unboundChanInt{
     return ssa.chnMids
     
@@ -210,9 +526,12 @@ func (ssa *SingleServerAgent) receiveFromServer() (string, []string) {
     for serverMsg == ""{
         dprintln("?")
         var err error
-        serverMsg, err = ssa.serverInConn.ReadString('\n')
+        serverMsg, err = readLine(ssa.serverInConn)
         if err != nil {
-            panic(err)
+            if !ssa.isClosed() {
+                ssa.reportError(fmt.Errorf("%w: %v", ErrDisconnected, err))
+            }
+            return "", nil
         }
     }
     dprintln(serverMsg)
@@ -233,5 +552,8 @@ func (ca *SingleServerAgent) GetSendTime() map[int]int64{
 }
 
 func (ca *SingleServerAgent) GetMaxMid() int {
-    return -1 
+    ca.lockMaxMid.Lock()
+    out := ca.maxMid
+    ca.lockMaxMid.Unlock()
+    return out
 }
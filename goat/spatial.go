@@ -0,0 +1,92 @@
+package goat
+
+import (
+    "fmt"
+)
+
+/*
+LocationX and LocationY are the attributes holding the position of a component
+on the plane, as ints.
+*/
+const (
+    LocationX = "x"
+    LocationY = "y"
+)
+
+// cdist is the closed form of WithinDistance.
+type cdist struct {
+    X interface{}
+    IsAttrX bool
+    Y interface{}
+    IsAttrY bool
+    R interface{}
+    IsAttrR bool
+}
+
+func (d cdist) Satisfy(attr *Attributes) bool {
+    vals := make([]int, 5)
+    args := []struct{
+        x interface{}
+        isAttr bool
+    }{{LocationX, true}, {LocationY, true}, {d.X, d.IsAttrX}, {d.Y, d.IsAttrY}, {d.R, d.IsAttrR}}
+    for i, arg := range args {
+        val, exists := toValue(attr, arg.x, arg.isAttr)
+        iVal, isInt := val.(int)
+        if !exists || !isInt {
+            return false
+        }
+        vals[i] = iVal
+    }
+    // in float64, the squares of ints far apart would overflow
+    dx, dy, r := float64(vals[0]) - float64(vals[2]), float64(vals[1]) - float64(vals[3]), float64(vals[4])
+    return r >= 0 && dx*dx + dy*dy <= r*r
+}
+
+func (d cdist) String() string {
+    return fmt.Sprintf("D(%s,%s,%s)", escapeWithType(d.X, d.IsAttrX), escapeWithType(d.Y, d.IsAttrY), escapeWithType(d.R, d.IsAttrR))
+}
+
+func (d cdist) CloseUnder(attr *Attributes) ClosedPredicate {
+    return d
+}
+
+type dist struct {
+    x interface{}
+    y interface{}
+    r interface{}
+}
+
+/*
+WithinDistance represents a predicate that is true iff the location of the
+receiver component (LocationX and LocationY) is within distance r from (x, y).
+As for comparisons, the arguments can be values or attributes of the sender
+(Comp) or of the receiver (Receiver); all of them must be ints.
+*/
+func WithinDistance(x interface{}, y interface{}, r interface{}) dist {
+    return dist{x, y, r}
+}
+
+func (d dist) CloseUnder(attr *Attributes) ClosedPredicate {
+    x, isAttrX := closure(d.x, attr)
+    y, isAttrY := closure(d.y, attr)
+    r, isAttrR := closure(d.r, attr)
+    return cdist{x, isAttrX, y, isAttrY, r, isAttrR}
+}
+
+/*
+Near is the predicate satisfied by the receivers within distance r from the
+sender.
+*/
+func Near(r interface{}) dist {
+    return WithinDistance(Comp(LocationX), Comp(LocationY), r)
+}
+
+/*
+MoveTo sets the location of the component of p to (x, y).
+*/
+func (p *Process) MoveTo(x int, y int) {
+    p.Set(func(attr *Attributes) {
+        attr.Set(LocationX, x)
+        attr.Set(LocationY, y)
+    })
+}
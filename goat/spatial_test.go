@@ -0,0 +1,45 @@
+package goat
+
+import (
+    "testing"
+)
+
+func TestWithinDistance(t *testing.T) {
+    sender := Attributes{}
+    sender.init(map[string]interface{}{LocationX: 0, LocationY: 0, "range": 5})
+    pred := Near(Comp("range")).CloseUnder(&sender)
+    decoded, err := ToPredicate(pred.String())
+    if err != nil || decoded.String() != pred.String() {
+        t.Fatal("the predicate must survive encoding", pred, decoded)
+    }
+    for _, c := range []struct{
+        x, y int
+        within bool
+    }{{3, 4, true}, {-3, -4, true}, {4, 4, false}, {0, 6, false}} {
+        receiver := Attributes{}
+        receiver.init(map[string]interface{}{LocationX: c.x, LocationY: c.y})
+        if decoded.Satisfy(&receiver) != c.within {
+            t.Fatal("wrong distance check at", c.x, c.y)
+        }
+    }
+    unlocated := getPrebuiltAttrs()
+    if decoded.Satisfy(unlocated) {
+        t.Fatal("components without location are not within any distance")
+    }
+}
+
+func TestWithinDistanceFar(t *testing.T) {
+    const far = 1 << 40
+    receiver := Attributes{}
+    receiver.init(map[string]interface{}{LocationX: far, LocationY: -far})
+    // the squares of the offsets overflow int
+    if WithinDistance(-far, far, 10).CloseUnder(&receiver).Satisfy(&receiver) {
+        t.Error("a component far away is not within distance 10")
+    }
+    if !WithinDistance(-far, far, 3 * far).CloseUnder(&receiver).Satisfy(&receiver) {
+        t.Error("a component at distance 3.1e12 is within distance 3.3e12")
+    }
+    if !WithinDistance(far + 3, -far + 4, 5).CloseUnder(&receiver).Satisfy(&receiver) {
+        t.Error("a component at distance 5 is within distance 5")
+    }
+}
@@ -0,0 +1,95 @@
+package goat
+
+import (
+    "math/rand"
+    "sync"
+    "time"
+)
+
+/*
+StochasticScheduler samples the delays of rated actions and the outcome of
+probabilistic choices. Rates are expressed in events per timeUnit of real time,
+hence a small timeUnit speeds up the execution of a model. Seeding the scheduler
+makes the sampled values reproducible (but not the interleaving of components).
+*/
+type StochasticScheduler struct {
+    lock *sync.Mutex
+    rnd *rand.Rand
+    timeUnit time.Duration
+}
+
+func NewStochasticScheduler(seed int64, timeUnit time.Duration) *StochasticScheduler {
+    return &StochasticScheduler{
+        lock: &sync.Mutex{},
+        rnd: rand.New(rand.NewSource(seed)),
+        timeUnit: timeUnit,
+    }
+}
+
+/*
+Delay samples the time before an action with the given rate happens, which is
+exponentially distributed.
+*/
+func (s *StochasticScheduler) Delay(rate float64) time.Duration {
+    if rate <= 0 {
+        panic("rates must be positive")
+    }
+    s.lock.Lock()
+    units := s.rnd.ExpFloat64() / rate
+    s.lock.Unlock()
+    return time.Duration(units * float64(s.timeUnit))
+}
+
+/*
+Choose returns i with probability weights[i] over the sum of weights.
+*/
+func (s *StochasticScheduler) Choose(weights ...float64) int {
+    total := 0.0
+    for _, w := range weights {
+        if w < 0 {
+            panic("weights cannot be negative")
+        }
+        total += w
+    }
+    if total == 0 {
+        panic("at least a weight must be positive")
+    }
+    s.lock.Lock()
+    x := s.rnd.Float64() * total
+    s.lock.Unlock()
+    for i, w := range weights {
+        if x < w {
+            return i
+        }
+        x -= w
+    }
+    return len(weights) - 1
+}
+
+/*
+Delay pauses p for a time sampled according to rate by the scheduler of the
+component. Any message received in the meantime is rejected.
+*/
+func (p *Process) Delay(rate float64) {
+    p.sleepFor(p.Comp.Scheduler().Delay(rate))
+}
+
+/*
+SendAtRate sends msg to the components satisfying pr after a delay sampled
+according to rate (see Delay).
+*/
+func (p *Process) SendAtRate(rate float64, msg Tuple, pr Predicate) {
+    p.Delay(rate)
+    p.Send(msg, pr)
+}
+
+/*
+Choose continues as branches[i] with probability weights[i] over the sum of
+weights.
+*/
+func (p *Process) Choose(weights []float64, branches ...func(*Process)) {
+    if len(weights) != len(branches) {
+        panic("each branch needs a weight")
+    }
+    p.Call(branches[p.Comp.Scheduler().Choose(weights...)])
+}
@@ -0,0 +1,39 @@
+package goat
+
+import (
+    "testing"
+    "time"
+)
+
+func TestStochasticSeed(t *testing.T) {
+    s1 := NewStochasticScheduler(42, time.Millisecond)
+    s2 := NewStochasticScheduler(42, time.Millisecond)
+    for i := 0; i < 100; i++ {
+        if s1.Delay(2) != s2.Delay(2) || s1.Choose(1, 2, 3) != s2.Choose(1, 2, 3) {
+            t.Fatal("same seeds must give the same samples")
+        }
+    }
+}
+
+func TestStochasticChoose(t *testing.T) {
+    s := NewStochasticScheduler(1, time.Millisecond)
+    counts := make([]int, 3)
+    for i := 0; i < 10000; i++ {
+        counts[s.Choose(1, 0, 3)]++
+    }
+    if counts[1] != 0 || counts[0] < 2000 || counts[0] > 3000 {
+        t.Fatal("unexpected distribution", counts)
+    }
+}
+
+func TestStochasticDelay(t *testing.T) {
+    s := NewStochasticScheduler(1, time.Second)
+    var total time.Duration
+    for i := 0; i < 10000; i++ {
+        total += s.Delay(4)
+    }
+    mean := total / 10000
+    if mean < 200 * time.Millisecond || mean > 300 * time.Millisecond {
+        t.Fatal("unexpected mean delay", mean)
+    }
+}
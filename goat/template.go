@@ -0,0 +1,104 @@
+package goat
+
+import "reflect"
+
+/*
+Bindings maps the placeholders of a Template to the values they matched.
+*/
+type Bindings map[string]interface{}
+
+type placeholder struct {
+    name string
+}
+
+/*
+Bind is a placeholder of a Template: it matches any value, that becomes bound to
+name. If name occurs more than once, all its occurrences must match the same
+value.
+*/
+func Bind(name string) placeholder {
+    return placeholder{name}
+}
+
+/*
+Template is a pattern for the messages to receive, e.g.
+
+    NewTemplate("bid", Bind("value")).Where(func(attr *Attributes, b Bindings) bool {
+        return b["value"].(int) > attr.GetValue("best").(int)
+    })
+
+Besides placeholders, the elements of a template can be values, that must be
+equal to the corresponding elements of the message, or Comp and Evaluate
+arguments, that are closed under the attributes of the receiver first.
+*/
+type Template struct {
+    elems []interface{}
+    cond func(attr *Attributes, b Bindings) bool
+}
+
+func NewTemplate(elems ...interface{}) Template {
+    return Template{elems, nil}
+}
+
+/*
+Where returns a copy of t that also requires cond to hold on the bindings.
+*/
+func (t Template) Where(cond func(attr *Attributes, b Bindings) bool) Template {
+    prev := t.cond
+    t.cond = func(attr *Attributes, b Bindings) bool {
+        return (prev == nil || prev(attr, b)) && cond(attr, b)
+    }
+    return t
+}
+
+/*
+Match returns the bindings and true iff msg matches t under attr.
+*/
+func (t Template) Match(attr *Attributes, msg Tuple) (Bindings, bool) {
+    if !msg.IsLong(len(t.elems)) {
+        return nil, false
+    }
+    b := Bindings{}
+    for i, elem := range t.elems {
+        switch telem := elem.(type) {
+            case placeholder:
+                if val, has := b[telem.name]; has && !reflect.DeepEqual(val, msg.Get(i)) {
+                    return nil, false
+                }
+                b[telem.name] = msg.Get(i)
+            case compattr, evalattr:
+                tpl := NewTuple(telem)
+                if !reflect.DeepEqual(tpl.CloseUnder(attr).Get(0), msg.Get(i)) {
+                    return nil, false
+                }
+            default:
+                if !reflect.DeepEqual(telem, msg.Get(i)) {
+                    return nil, false
+                }
+        }
+    }
+    if t.cond != nil && !t.cond(attr, b) {
+        return nil, false
+    }
+    return b, true
+}
+
+/*
+ReceiveMatch blocks p until a message matching t is received, and returns it
+together with its bindings. If upd is not nil, it is applied to the attributes
+atomically with the reception, e.g. to record the new best bid.
+*/
+func (p *Process) ReceiveMatch(t Template, upd func(attr *Attributes, b Bindings)) (Tuple, Bindings) {
+    var bindings Bindings
+    msg := p.Receive(func(attr *Attributes, msg Tuple) bool {
+        b, matches := t.Match(attr, msg)
+        if matches {
+            bindings = b
+            if upd != nil {
+                upd(attr, b)
+            }
+        }
+        return matches
+    })
+    return msg, bindings
+}
@@ -0,0 +1,29 @@
+package goat
+
+import (
+    "testing"
+)
+
+func TestTemplateMatch(t *testing.T) {
+    attr := Attributes{}
+    attr.init(map[string]interface{}{"best": 10, "item": "lamp"})
+    tpl := NewTemplate("bid", Comp("item"), Bind("value")).Where(func(attr *Attributes, b Bindings) bool {
+        return b["value"].(int) > attr.GetValue("best").(int)
+    })
+    if b, ok := tpl.Match(&attr, NewTuple("bid", "lamp", 12)); !ok || b["value"] != 12 {
+        t.Fatal("a higher bid must match", b)
+    }
+    if _, ok := tpl.Match(&attr, NewTuple("bid", "lamp", 8)); ok {
+        t.Fatal("a lower bid must not match")
+    }
+    if _, ok := tpl.Match(&attr, NewTuple("bid", "sofa", 12)); ok {
+        t.Fatal("a bid for another item must not match")
+    }
+    if _, ok := tpl.Match(&attr, NewTuple("bid", "lamp")); ok {
+        t.Fatal("a shorter message must not match")
+    }
+    pair := NewTemplate(Bind("x"), Bind("x"))
+    if _, ok := pair.Match(&attr, NewTuple(1, 2)); ok {
+        t.Fatal("a repeated placeholder must match equal values")
+    }
+}
@@ -0,0 +1,18 @@
+package goat
+
+import "time"
+
+var timeSource func() time.Time = time.Now
+
+/*
+SetTimeSource replaces the clock used by the library (time.Now by default),
+e.g. to use the browser clock or a simulated one. It must be called before any
+component or agent is created.
+*/
+func SetTimeSource(now func() time.Time) {
+    timeSource = now
+}
+
+func timeNow() time.Time {
+    return timeSource()
+}
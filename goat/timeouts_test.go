@@ -0,0 +1,216 @@
+package goat
+
+import (
+    "testing"
+    "time"
+)
+
+// within waits up to five seconds for a value on chn.
+func within(t *testing.T, chn chan interface{}, what string) interface{} {
+    select {
+        case val := <-chn:
+            return val
+        case <-time.After(5 * time.Second):
+            t.Fatal(what)
+            return nil
+    }
+}
+
+func TestReceiveWithin(t *testing.T) {
+    server := testServer(17739)
+    r := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "r"})
+    s := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "s"})
+    results := make(chan interface{}, 2)
+    r.Start(func(p *Process) {
+        start := time.Now()
+        msg, received := p.ReceiveWithin(100, func(*Attributes, Tuple) bool { return true })
+        if received || msg.Length() != 0 || time.Since(start) < 100 * time.Millisecond {
+            t.Error("nothing was sent, the receive must expire", msg, received, time.Since(start))
+        }
+        results <- "expired"
+        msg, received = p.ReceiveWithin(5000, func(*Attributes, Tuple) bool { return true })
+        if !received {
+            t.Error("the message was not received in time")
+        }
+        results <- msg
+    })
+    within(t, results, "ReceiveWithin did not expire")
+    s.Start(func(p *Process) {
+        p.Send(NewTuple("hi"), Equals(Receiver("name"), "r"))
+    })
+    if msg := within(t, results, "ReceiveWithin did not return").(Tuple); msg.Get(0) != "hi" {
+        t.Error(msg)
+    }
+}
+
+func TestSendWithinWithdrawsMid(t *testing.T) {
+    server := testServer(17740)
+    r := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "r"})
+    s := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "s", "ready": false, "n": 0})
+    other := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "other"})
+    got := make(chan interface{}, 4)
+    r.Start(func(p *Process) {
+        for {
+            got <- p.Receive(func(*Attributes, Tuple) bool { return true }).Get(0)
+        }
+    })
+    toR := Equals(Receiver("name"), "r")
+    results := make(chan interface{}, 4)
+    s.Start(func(p *Process) {
+        sent := p.GSendUpdWithin(100, Equals(Comp("ready"), true), NewTuple("guarded"), toR, func(attr *Attributes) {
+            attr.Set("n", 1)
+        })
+        results <- sent
+        p.Set(func(attr *Attributes) {
+            results <- attr.GetValue("n")
+        })
+        results <- p.SendWithin(5000, NewTuple("after"), toR)
+    })
+    if sent := within(t, results, "GSendUpdWithin did not expire"); sent != false {
+        t.Fatal("the guard is false, the message must not be sent")
+    }
+    if n := within(t, results, "no update"); n != 0 {
+        t.Error("the update of an expired send must not be applied, n =", n)
+    }
+    if sent := within(t, results, "SendWithin did not return"); sent != true {
+        t.Error("SendWithin failed")
+    }
+    if msg := within(t, got, "the send after the expired one is blocked"); msg != "after" {
+        t.Error("the expired message was sent:", msg)
+    }
+    // the mid requested for the expired send does not block the others
+    other.Start(func(p *Process) {
+        p.Send(NewTuple("other"), toR)
+    })
+    if msg := within(t, got, "the sends of the other components are blocked"); msg != "other" {
+        t.Error(msg)
+    }
+}
+
+func TestSelectWithin(t *testing.T) {
+    server := testServer(17741)
+    r := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "r"})
+    s := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "s", "go": false})
+    got := make(chan interface{}, 4)
+    r.Start(func(p *Process) {
+        for {
+            got <- p.Receive(func(*Attributes, Tuple) bool { return true }).Get(0)
+        }
+    })
+    toR := Equals(Receiver("name"), "r")
+    toS := Equals(Receiver("name"), "s")
+    results := make(chan interface{}, 8)
+    then := func(what string) func(*Process) {
+        return func(*Process) {
+            results <- what
+        }
+    }
+    receiveAll := ThenReceive(func(*Attributes, Tuple) bool { return true })
+    s.Start(func(p *Process) {
+        // nothing can be taken: the cases are those of the wrong kind
+        results <- p.SelectWithin(100, then("timeout"),
+            Case(Equals(Comp("go"), true), ThenSend(NewTuple("never"), toR.CloseUnder(nil)), then("sent")),
+            Case(True(), ThenReceive(func(*Attributes, Tuple) bool { return false }), then("received")))
+        // a receive case never takes a mid, even if it comes first
+        results <- p.SelectWithin(5000, then("timeout"),
+            Case(True(), receiveAll, then("received")),
+            Case(True(), ThenSend(NewTuple("select"), toR.CloseUnder(nil)), then("sent")))
+        // a message is offered to the receive cases only
+        results <- p.SelectWithin(5000, then("timeout"),
+            Case(Equals(Comp("go"), true), ThenSend(NewTuple("never"), toR.CloseUnder(nil)), then("sent")),
+            Case(True(), receiveAll, then("received")))
+    })
+    if what := within(t, results, "SelectWithin did not expire"); what != "timeout" {
+        t.Fatal("the select must expire, not take", what)
+    }
+    if taken := within(t, results, "no result"); taken != false {
+        t.Error("SelectWithin must return false on expiry")
+    }
+    if what := within(t, results, "the send case was not taken"); what != "sent" {
+        t.Error(what, "instead of the send case")
+    }
+    if taken := within(t, results, "no result"); taken != true {
+        t.Error("SelectWithin must return true when a case is taken")
+    }
+    if msg := within(t, got, "the message of the send case was not delivered"); msg != "select" {
+        t.Error("the mid of the expired select was used:", msg)
+    }
+    NewComponent(NewSingleServerAgent(server), nil).Start(func(p *Process) {
+        p.Send(NewTuple("to s"), toS)
+    })
+    if what := within(t, results, "the receive case was not taken"); what != "received" {
+        t.Error(what, "instead of the receive case")
+    }
+    if taken := within(t, results, "no result"); taken != true {
+        t.Error("SelectWithin must return true when a case is taken")
+    }
+}
+
+func TestClock(t *testing.T) {
+    before := int(time.Now().UnixNano() / int64(time.Millisecond))
+    now, _ := closure(Clock(), NewAttributes())
+    after := int(time.Now().UnixNano() / int64(time.Millisecond))
+    if ms, isInt := now.(int); !isInt || ms < before || ms > after {
+        t.Fatal("Clock must evaluate to the current time in milliseconds", now, before, after)
+    }
+    receiver := Attributes{}
+    receiver.init(map[string]interface{}{"until": after + 60000})
+    pred := LessThan(Clock(), Receiver("until")).CloseUnder(NewAttributes())
+    decoded, err := ToPredicate(pred.String())
+    if err != nil || !decoded.Satisfy(&receiver) {
+        t.Error("the deadline has not passed", pred, err)
+    }
+    receiver.init(map[string]interface{}{"until": before - 60000})
+    if decoded.Satisfy(&receiver) {
+        t.Error("the deadline has passed", pred)
+    }
+}
+
+func TestScatterGather(t *testing.T) {
+    server := testServer(17743)
+    for i := 0; i < 3; i++ {
+        NewComponent(NewSingleServerAgent(server), map[string]interface{}{"role": "worker", "id": i}).Start(func(p *Process) {
+            for {
+                query := p.Receive(func(attr *Attributes, msg Tuple) bool {
+                    return msg.Get(0) == "query"
+                })
+                p.Send(NewTuple("reply", query.Get(1), Comp("id")), Equals(Receiver("role"), "coordinator"))
+            }
+        })
+    }
+    coordinator := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"role": "coordinator"})
+    toWorkers := Equals(Receiver("role"), "worker")
+    correlation := func(query int) func(*Attributes, Tuple) bool {
+        return func(attr *Attributes, reply Tuple) bool {
+            return reply.Get(0) == "reply" && reply.Get(1) == query
+        }
+    }
+    type gathered struct {
+        replies []Tuple
+        elapsed time.Duration
+    }
+    results := make(chan interface{}, 2)
+    coordinator.Start(func(p *Process) {
+        start := time.Now()
+        replies := p.ScatterGather(NewTuple("query", 1), toWorkers, correlation(1), 2, 5000)
+        results <- gathered{replies, time.Since(start)}
+        start = time.Now()
+        replies = p.ScatterGather(NewTuple("query", 2), toWorkers, correlation(2), 5, 300)
+        results <- gathered{replies, time.Since(start)}
+    })
+    first := within(t, results, "ScatterGather did not return after k replies").(gathered)
+    if len(first.replies) != 2 || first.elapsed >= 5 * time.Second {
+        t.Error("ScatterGather must return as soon as k replies are received:", first.replies, first.elapsed)
+    }
+    second := within(t, results, "ScatterGather did not return at the deadline").(gathered)
+    if len(second.replies) != 3 || second.elapsed < 300 * time.Millisecond {
+        t.Error("with fewer than k replies, ScatterGather must return them at the deadline:", second.replies, second.elapsed)
+    }
+    ids := map[interface{}]bool{}
+    for _, reply := range second.replies {
+        ids[reply.Get(2)] = true
+    }
+    if len(ids) != 3 {
+        t.Error("a reply for each worker expected, got", second.replies)
+    }
+}
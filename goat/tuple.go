@@ -83,16 +83,21 @@ func (t *Tuple) encode() string{
 }
 
 func decodeTuple(encoded string) Tuple{
+    t, err := decodeTupleErr(encoded)
+	if err != nil {
+		log.Fatal("Tuple decoding error:", err)
+	}
+	return t
+}
+
+func decodeTupleErr(encoded string) (Tuple, error){
     decoded, err := base64.StdEncoding.DecodeString(encoded)
 	if err != nil {
-		log.Fatal("Base64 decoding error:", err)
+		return Tuple{}, err
 	}
 	network := bytes.NewBuffer(decoded)
 	dec := gob.NewDecoder(network)
 	var t Tuple
 	err = dec.Decode(&t)
-	if err != nil {
-		log.Fatal("Tuple decoding error:", err)
-	}
-	return t
+	return t, err
 }
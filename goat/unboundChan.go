@@ -1,5 +1,7 @@
 package goat
 
+import "sync/atomic"
+
 type unboundChanUnit struct {
     In chan struct{}
     Out chan struct{}
@@ -97,6 +99,8 @@ func newUnboundChanString() *unboundChanString {
 type unboundChanMessage struct {
     In chan Message
     Out chan Message
+    // buffered is the number of messages in the buffer, see Len
+    buffered *int64
 }
 func (uc *unboundChanMessage) start(){
     buffer := []Message{}
@@ -108,15 +112,21 @@ func (uc *unboundChanMessage) start(){
                 case d := <- uc.In:
                     buffer = append(buffer, d)
             }
+            atomic.StoreInt64(uc.buffered, int64(len(buffer)))
         }
         for len(buffer) == 0 {
             d := <- uc.In
             buffer = append(buffer, d)
         }
+        atomic.StoreInt64(uc.buffered, int64(len(buffer)))
     }
 }
+// Len returns the number of messages sent on In and not yet received from Out.
+func (uc *unboundChanMessage) Len() int {
+    return int(atomic.LoadInt64(uc.buffered))
+}
 func newUnboundChanMessage() *unboundChanMessage {
-    uc := unboundChanMessage{make(chan Message), make(chan Message)}
+    uc := unboundChanMessage{make(chan Message), make(chan Message), new(int64)}
     go func(c *unboundChanMessage){c.start()}(&uc)
     return &uc
 }
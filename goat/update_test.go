@@ -0,0 +1,158 @@
+package goat
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestWithdrawnUpdateRollsBack(t *testing.T) {
+    server := testServer(17744)
+    c := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"a": 0, "b": 0})
+    r := NewComponent(NewSingleServerAgent(server), map[string]interface{}{"name": "r"})
+    got := make(chan interface{}, 2)
+    r.Start(func(p *Process) {
+        for {
+            got <- p.Receive(func(*Attributes, Tuple) bool { return true }).Get(0)
+        }
+    })
+    toR := Equals(Receiver("name"), "r")
+    stop := make(chan struct{})
+    torn := make(chan interface{}, 1)
+    // a and b always change together: nobody may see one without the other
+    c.Start(func(p *Process) {
+        for {
+            select {
+                case <-stop:
+                    return
+                default:
+            }
+            p.Set(func(attr *Attributes) {
+                if attr.GetValue("a") != attr.GetValue("b") {
+                    select {
+                        case torn <- [2]interface{}{attr.GetValue("a"), attr.GetValue("b")}:
+                        default:
+                    }
+                }
+            })
+        }
+    })
+    results := make(chan interface{}, 4)
+    c.Start(func(p *Process) {
+        // the update is withdrawn halfway, at every attempt
+        results <- p.GSendUpdWithin(300, True(), NewTuple("withdrawn"), toR, func(attr *Attributes) {
+            attr.Set("a", 1)
+            panic("halfway")
+        })
+        p.Set(func(attr *Attributes) {
+            results <- [2]interface{}{attr.GetValue("a"), attr.GetValue("b")}
+        })
+        results <- p.SendWithin(5000, NewTuple("applied"), toR)
+        p.GSendUpd(True(), NewTuple("applied"), toR, func(attr *Attributes) {
+            attr.Set("a", 2)
+            attr.Set("b", 2)
+        })
+        p.Set(func(attr *Attributes) {
+            results <- [2]interface{}{attr.GetValue("a"), attr.GetValue("b")}
+        })
+        close(stop)
+    })
+    if sent := within(t, results, "the withdrawn send did not expire"); sent != false {
+        t.Fatal("a send whose update panics must not be sent")
+    }
+    if ab := within(t, results, "no attributes"); ab != [2]interface{}{0, 0} {
+        t.Error("the withdrawn update must be rolled back, a and b are", ab)
+    }
+    if sent := within(t, results, "the send after the withdrawn one is blocked"); sent != true {
+        t.Error("SendWithin failed")
+    }
+    if ab := within(t, results, "no attributes"); ab != [2]interface{}{2, 2} {
+        t.Error("the update must be applied with its message, a and b are", ab)
+    }
+    for i := 0; i < 2; i++ {
+        if msg := within(t, got, "message not delivered"); msg != "applied" {
+            t.Error("the withdrawn message was sent:", msg)
+        }
+    }
+    select {
+        case ab := <-torn:
+            t.Error("an update was partly visible:", ab)
+        default:
+    }
+    var panicErr *PanicError
+    select {
+        case err := <-c.Errors():
+            if !errors.As(err, &panicErr) || panicErr.Value != "halfway" {
+                t.Error(err)
+            }
+        case <-time.After(5 * time.Second):
+            t.Error("the panic in the update was not reported")
+    }
+}
+
+func TestThenSetReacts(t *testing.T) {
+    c := NewComponent(NewSingleServerAgent(testServer(17745)), map[string]interface{}{
+        "temp": 20, "alarm": false, "mode": "auto", "humidity": 50,
+    })
+    fired := make(chan interface{}, 4)
+    c.Start(func(p *Process) {
+        p.Select(Case(GreaterThan(Comp("temp"), 30), ThenSet(func(attr *Attributes) {
+            attr.Set("alarm", true)
+        }), func(*Process) {
+            fired <- "alarm"
+        }))
+    }, func(p *Process) {
+        p.Select(Case(Equals(Comp("mode"), "manual"), ThenReact(), func(*Process) {
+            fired <- "manual"
+        }))
+    })
+    set := func(name string, val interface{}) {
+        done := make(chan struct{})
+        c.Start(func(p *Process) {
+            p.Set(func(attr *Attributes) {
+                attr.Set(name, val)
+            })
+            close(done)
+        })
+        <-done
+    }
+    read := func(name string) interface{} {
+        val := make(chan interface{})
+        c.Start(func(p *Process) {
+            p.Set(func(attr *Attributes) {
+                val <- attr.GetValue(name)
+            })
+        })
+        return <-val
+    }
+    notFired := func(why string) {
+        select {
+            case what := <-fired:
+                t.Fatal(what, "fired", why)
+            case <-time.After(200 * time.Millisecond):
+        }
+    }
+
+    notFired("before any change")
+    set("humidity", 80)
+    set("temp", 25)
+    notFired("after changes that leave the predicates false")
+    if read("alarm") != false {
+        t.Fatal("the alarm was set")
+    }
+    set("temp", 35)
+    if what := within(t, fired, "ThenSet did not react to the change"); what != "alarm" {
+        t.Fatal(what, "fired instead of alarm")
+    }
+    if read("alarm") != true {
+        t.Error("the update of ThenSet was not applied")
+    }
+    set("mode", "manual")
+    if what := within(t, fired, "ThenReact did not react to the change"); what != "manual" {
+        t.Fatal(what, "fired instead of manual")
+    }
+    if read("mode") != "manual" || read("temp") != 35 {
+        t.Error("ThenReact must leave the attributes unchanged")
+    }
+    notFired("twice")
+}
@@ -114,7 +114,11 @@ func unescapeWithType(s string, from int) (interface{}, bool, int) {
         case 'T':
         {
             tdata, nextItem := unescape(s, from+2)
-            return decodeTuple(tdata), false, nextItem
+            t, err := decodeTupleErr(tdata)
+            if err != nil {
+                panic(err)
+            }
+            return t, false, nextItem
         }
         case 'X': //TODO gob!
         {
@@ -213,7 +217,7 @@ func receiveWithAddressTimeoutErr(listener net.Listener, msec int64, timedOut *b
     if err != nil {
         return "", []string{}, netAddress{}, err
     }
-    serverMsg, err := bufio.NewReader(conn).ReadString('\n')
+    serverMsg, err := readLine(bufio.NewReader(conn))
     if err == nil {
         escTokens := strings.Split(serverMsg[:len(serverMsg)-1], " ")
         tokens := make([]string, len(escTokens))
@@ -246,7 +250,7 @@ func receiveWithAddressTimeout(listener net.Listener, msec int64, timedOut *bool
     if err != nil {
         panic(err)
     }
-    serverMsg, err := bufio.NewReader(conn).ReadString('\n')
+    serverMsg, err := readLine(bufio.NewReader(conn))
     if err == nil {
         escTokens := strings.Split(serverMsg[:len(serverMsg)-1], " ")
         tokens := make([]string, len(escTokens))
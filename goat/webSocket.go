@@ -0,0 +1,193 @@
+package goat
+
+import (
+    "bufio"
+    "crypto/sha1"
+    "encoding/base64"
+    "encoding/binary"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+)
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// webSocketMaxMessage is the maximum size of a message (i.e. of all its
+// frames) read from a WebSocket; the connection is closed if it is exceeded.
+const webSocketMaxMessage = 16 << 20
+
+var errWebSocketTooLarge = errors.New("WebSocket message too large")
+
+const (
+    wsOpContinuation = 0x0
+    wsOpText = 0x1
+    wsOpBinary = 0x2
+    wsOpClose = 0x8
+    wsOpPing = 0x9
+    wsOpPong = 0xA
+)
+
+// wsConn is the server side of a WebSocket connection (RFC 6455). Every text
+// message carries one line of the goat protocol.
+type wsConn struct {
+    conn net.Conn
+    reader *bufio.Reader
+    lock *sync.Mutex
+}
+
+func webSocketAccept(key string) string {
+    h := sha1.New()
+    h.Write([]byte(key + webSocketGUID))
+    return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+    key := r.Header.Get("Sec-WebSocket-Key")
+    if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+        http.Error(w, "expected a WebSocket connection", http.StatusBadRequest)
+        return nil, errors.New("not a WebSocket handshake")
+    }
+    hj, canHijack := w.(http.Hijacker)
+    if !canHijack {
+        http.Error(w, "cannot upgrade the connection", http.StatusInternalServerError)
+        return nil, errors.New("the connection cannot be hijacked")
+    }
+    conn, rw, err := hj.Hijack()
+    if err != nil {
+        return nil, err
+    }
+    rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+        "Upgrade: websocket\r\n" +
+        "Connection: Upgrade\r\n" +
+        "Sec-WebSocket-Accept: " + webSocketAccept(key) + "\r\n\r\n")
+    if err = rw.Flush(); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    return &wsConn{conn, rw.Reader, &sync.Mutex{}}, nil
+}
+
+func (wc *wsConn) writeFrame(opcode byte, payload []byte) error {
+    header := []byte{0x80 | opcode}
+    switch {
+        case len(payload) < 126:
+            header = append(header, byte(len(payload)))
+        case len(payload) <= 0xFFFF:
+            header = append(header, 126, 0, 0)
+            binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+        default:
+            header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+            binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+    }
+    wc.lock.Lock()
+    defer wc.lock.Unlock()
+    if _, err := wc.conn.Write(header); err != nil {
+        return err
+    }
+    _, err := wc.conn.Write(payload)
+    return err
+}
+
+// readFrame reads the next frame, which must not be longer than maxLength.
+func (wc *wsConn) readFrame(maxLength uint64) (bool, byte, []byte, error) {
+    var head [2]byte
+    if _, err := io.ReadFull(wc.reader, head[:]); err != nil {
+        return false, 0, nil, err
+    }
+    fin := head[0] & 0x80 != 0
+    opcode := head[0] & 0x0F
+    masked := head[1] & 0x80 != 0
+    length := uint64(head[1] & 0x7F)
+    switch length {
+        case 126:
+            var ext [2]byte
+            if _, err := io.ReadFull(wc.reader, ext[:]); err != nil {
+                return false, 0, nil, err
+            }
+            length = uint64(binary.BigEndian.Uint16(ext[:]))
+        case 127:
+            var ext [8]byte
+            if _, err := io.ReadFull(wc.reader, ext[:]); err != nil {
+                return false, 0, nil, err
+            }
+            length = binary.BigEndian.Uint64(ext[:])
+    }
+    if length > maxLength {
+        return false, 0, nil, errWebSocketTooLarge
+    }
+    var mask [4]byte
+    if masked {
+        if _, err := io.ReadFull(wc.reader, mask[:]); err != nil {
+            return false, 0, nil, err
+        }
+    }
+    payload := make([]byte, length)
+    if _, err := io.ReadFull(wc.reader, payload); err != nil {
+        return false, 0, nil, err
+    }
+    if masked {
+        for i := range payload {
+            payload[i] ^= mask[i%4]
+        }
+    }
+    return fin, opcode, payload, nil
+}
+
+// ReceiveErr reads the next message, answering pings and joining fragments.
+func (wc *wsConn) ReceiveErr() (string, []string, error) {
+    message := []byte{}
+    for {
+        fin, opcode, payload, err := wc.readFrame(uint64(webSocketMaxMessage - len(message)))
+        if err == errWebSocketTooLarge {
+            // close status 1009, message too big
+            wc.writeFrame(wsOpClose, []byte{0x03, 0xF1})
+        }
+        if err != nil {
+            return "", nil, err
+        }
+        switch opcode {
+            case wsOpPing:
+                wc.writeFrame(wsOpPong, payload)
+            case wsOpPong:
+            case wsOpClose:
+                wc.writeFrame(wsOpClose, nil)
+                return "", nil, io.EOF
+            case wsOpText, wsOpBinary, wsOpContinuation:
+                message = append(message, payload...)
+                if fin {
+                    cmd, params := decodeLine(string(message))
+                    return cmd, params, nil
+                }
+        }
+    }
+}
+
+func (wc *wsConn) Send(tokens ...string) error {
+    return wc.writeFrame(wsOpText, []byte(encodeLine(tokens...)))
+}
+
+func (wc *wsConn) Close() {
+    wc.conn.Close()
+}
+
+// encodeLine and decodeLine convert between tokens and a protocol line
+// (without the trailing newline).
+func encodeLine(tokens ...string) string {
+    escTokens := make([]string, len(tokens))
+    for i, tok:= range tokens {
+        escTokens[i] = escape(tok)
+    }
+    return strings.Join(escTokens, " ")
+}
+
+func decodeLine(line string) (string, []string) {
+    escTokens := strings.Split(line, " ")
+    tokens := make([]string, len(escTokens))
+    for i, escTok := range escTokens {
+        tokens[i], _ = unescape(escTok, 0)
+    }
+    return tokens[0], tokens[1:]
+}
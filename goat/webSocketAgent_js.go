@@ -0,0 +1,159 @@
+// +build js,wasm
+
+package goat
+
+import (
+    "sync"
+    "syscall/js"
+)
+
+/*
+WebSocketAgent is the agent to be used when the component runs in a browser
+(GOOS=js GOARCH=wasm), where raw TCP is not available. It connects to a
+WebSocketGateway at url (e.g. "ws://host:port/goat") through the browser's
+WebSocket API.
+*/
+type WebSocketAgent struct {
+    url string
+    componentId int
+    firstMessageId int
+    maxMid int
+    ws js.Value
+    chnMids *unboundChanInt
+    chnMessagesIn *unboundChanMessage
+    chnLines *unboundChanString
+    lockST *sync.Mutex
+    lockWS *sync.Mutex
+    onError func(error)
+}
+
+func NewWebSocketAgent(url string) *WebSocketAgent {
+    return &WebSocketAgent{
+        url: url,
+        firstMessageId: -1,
+        maxMid: -1,
+        chnMids: newUnboundChanInt(),
+        chnMessagesIn: newUnboundChanMessage(),
+        chnLines: newUnboundChanString(),
+        lockST: &sync.Mutex{},
+        lockWS: &sync.Mutex{},
+    }
+}
+
+func (wa *WebSocketAgent) SetErrorHandler(handler func(error)) {
+    wa.onError = handler
+}
+
+// NewSibling returns a new agent for the same gateway.
+func (wa *WebSocketAgent) NewSibling() Agent {
+    return NewWebSocketAgent(wa.url)
+}
+
+func (wa *WebSocketAgent) Start() {
+    chnOpen := make(chan struct{})
+    chnRegistered := make(chan struct{})
+    wa.ws = js.Global().Get("WebSocket").New(wa.url)
+    wa.ws.Set("onopen", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+        close(chnOpen)
+        return nil
+    }))
+    wa.ws.Set("onclose", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+        if wa.onError != nil {
+            wa.onError(ErrDisconnected)
+        }
+        return nil
+    }))
+    wa.ws.Set("onmessage", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+        // the unbound channel never blocks for long, and keeps the lines in order
+        wa.chnLines.In <- args[0].Get("data").String()
+        return nil
+    }))
+    go func(){
+        for {
+            cmd, params := decodeLine(<- wa.chnLines.Out)
+            switch cmd {
+                case "Registered":
+                    wa.componentId = atoi(params[0])
+                    wa.firstMessageId = atoi(params[1])
+                    wa.maxMid = wa.firstMessageId
+                    close(chnRegistered)
+                case "RPLY":
+                    wa.chnMids.In <- atoi(params[0])
+                case "DATA":
+                    pred := decodePredicate(params[2], wa.onError)
+                    mid := atoi(params[0])
+                    wa.lockST.Lock()
+                    if mid > wa.maxMid {
+                        wa.maxMid = mid
+                    }
+                    wa.lockST.Unlock()
+                    wa.chnMessagesIn.In <- Message{
+                        Id: mid,
+                        Pred: pred,
+                        Message: decodeTuple(params[3]),
+                        Sender: atoi(params[1]),
+                    }
+            }
+        }
+    }()
+    <-chnOpen
+    <-chnRegistered
+}
+
+func (wa *WebSocketAgent) send(tokens ...string) {
+    wa.lockWS.Lock()
+    wa.ws.Call("send", encodeLine(tokens...))
+    wa.lockWS.Unlock()
+}
+
+func (wa *WebSocketAgent) SendMessage(msg Message) {
+    wa.lockST.Lock()
+    if msg.Id > wa.maxMid {
+        wa.maxMid = msg.Id
+    }
+    wa.lockST.Unlock()
+    wa.send("DATA", itoa(msg.Id), itoa(wa.componentId), msg.Pred.String(), msg.Message.encode())
+}
+
+func (wa *WebSocketAgent) AskMid() {
+    wa.send("REQ", itoa(wa.componentId))
+}
+
+// AskMidPriority asks a mid for a send with the given priority.
+func (wa *WebSocketAgent) AskMidPriority(priority int) {
+    wa.send("REQ", itoa(wa.componentId), itoa(priority))
+}
+
+func (wa *WebSocketAgent) GetRplyChan() *unboundChanInt {
+    return wa.chnMids
+}
+
+func (wa *WebSocketAgent) GetDataChan() *unboundChanMessage {
+    return wa.chnMessagesIn
+}
+
+func (wa *WebSocketAgent) GetComponentId() int {
+    return wa.componentId
+}
+
+func (wa *WebSocketAgent) GetFirstMessageId() int {
+    return wa.firstMessageId
+}
+
+func (wa *WebSocketAgent) GetMaxMid() int {
+    wa.lockST.Lock()
+    out := wa.maxMid
+    wa.lockST.Unlock()
+    return out
+}
+
+// GetReceiveTime returns an empty map: WebSocketAgent does not record receive
+// times.
+func (wa *WebSocketAgent) GetReceiveTime() map[int]int64 {
+    return map[int]int64{}
+}
+
+// GetSendTime returns an empty map: WebSocketAgent does not record send times.
+func (wa *WebSocketAgent) GetSendTime() map[int]int64 {
+    return map[int]int64{}
+}
@@ -0,0 +1,159 @@
+package goat
+
+import (
+    "net/http"
+    "sync"
+)
+
+/*
+WebSocketGateway lets components without raw TCP access (e.g. running in a
+browser, see WebSocketAgent) join an infrastructure. It is an http.Handler:
+every WebSocket connection gets its own agent, created by newAgent, and the
+gateway relays the protocol lines between the two. When the connection drops,
+the agent is closed if it implements ClosingAgent, so that the infrastructure
+forgets the component. Custom types exchanged in messages must be registered
+with gob in the gateway too.
+*/
+type WebSocketGateway struct {
+    newAgent func() Agent
+    onError func(error)
+}
+
+func NewWebSocketGateway(newAgent func() Agent) *WebSocketGateway {
+    return &WebSocketGateway{newAgent: newAgent}
+}
+
+/*
+SetErrorHandler sets a function called with the errors of the gateway, e.g. a
+panic recovered while serving a malformed line, which is then dropped. It must
+be called before the gateway serves connections.
+*/
+func (wg *WebSocketGateway) SetErrorHandler(handler func(error)) {
+    wg.onError = handler
+}
+
+func (wg *WebSocketGateway) reportError(err error) {
+    if wg.onError != nil {
+        wg.onError(err)
+    } else {
+        dprintln("WebSocket gateway error:", err)
+    }
+}
+
+func (wg *WebSocketGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    conn, err := upgradeWebSocket(w, r)
+    if err != nil {
+        return
+    }
+    defer conn.Close()
+    agent := wg.newAgent()
+    agent.Start()
+    conn.Send("Registered", itoa(agent.GetComponentId()), itoa(agent.GetFirstMessageId()))
+
+    // mids assigned to the remote component that have not been used yet: if
+    // the connection drops they are filled with empty messages, otherwise the
+    // total order would stall.
+    lock := &sync.Mutex{}
+    pending := map[int]struct{}{}
+    closed := false
+    emptyMessage := func(mid int) Message {
+        return makeMessage(messagePredicate{invalid: true}, mid)
+    }
+
+    chnDone := make(chan struct{})
+    go func() {
+        for {
+            var mid int
+            select {
+                case mid = <- agent.GetRplyChan().Out:
+                case <- chnDone:
+                    return
+            }
+            lock.Lock()
+            if closed {
+                lock.Unlock()
+                agent.SendMessage(emptyMessage(mid))
+                continue
+            }
+            pending[mid] = struct{}{}
+            lock.Unlock()
+            conn.Send("RPLY", itoa(mid))
+        }
+    }()
+    go func() {
+        for {
+            var msg Message
+            select {
+                case msg = <- agent.GetDataChan().Out:
+                case <- chnDone:
+                    return
+            }
+            lock.Lock()
+            isClosed := closed
+            lock.Unlock()
+            if !isClosed {
+                conn.Send("DATA", itoa(msg.Id), itoa(msg.Sender), msg.Pred.String(), msg.Message.encode())
+            }
+        }
+    }()
+
+    // relayLine relays a line of the remote component to agent
+    relayLine := func(cmd string, params []string) {
+        switch cmd {
+            case "REQ": // REQ cid [priority]
+                pa, hasPriorities := agent.(PriorityAgent)
+                if len(params) > 1 && hasPriorities {
+                    pa.AskMidPriority(atoi(params[1]))
+                } else {
+                    agent.AskMid()
+                }
+            case "DATA": // DATA mid cid pred msg
+                if len(params) < 4 {
+                    return
+                }
+                mid := atoi(params[0])
+                lock.Lock()
+                _, isPending := pending[mid]
+                delete(pending, mid)
+                lock.Unlock()
+                if !isPending {
+                    // not a mid of this component
+                    return
+                }
+                pred := decodePredicate(params[2], wg.reportError)
+                msg, err := decodeTupleErr(params[3])
+                if err != nil {
+                    agent.SendMessage(emptyMessage(mid))
+                    return
+                }
+                agent.SendMessage(Message{
+                    Id: mid,
+                    Pred: pred,
+                    Message: msg,
+                })
+        }
+    }
+
+    for {
+        cmd, params, err := conn.ReceiveErr()
+        if err != nil {
+            lock.Lock()
+            closed = true
+            toFill := pending
+            pending = map[int]struct{}{}
+            lock.Unlock()
+            for mid := range toFill {
+                agent.SendMessage(emptyMessage(mid))
+            }
+            close(chnDone)
+            if ca, canClose := agent.(ClosingAgent); canClose {
+                ca.Close()
+            }
+            return
+        }
+        // a malformed line is dropped, the connection stays open
+        catchPanic("the WebSocket gateway", wg.reportError, func() {
+            relayLine(cmd, params)
+        })
+    }
+}
@@ -0,0 +1,124 @@
+package goat
+
+import (
+    "bufio"
+    "fmt"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+    "time"
+)
+
+func maskedFrame(fin bool, opcode byte, payload string) []byte {
+    first := opcode
+    if fin {
+        first |= 0x80
+    }
+    mask := []byte{1, 2, 3, 4}
+    frame := append([]byte{first, 0x80 | byte(len(payload))}, mask...)
+    for i := 0; i < len(payload); i++ {
+        frame = append(frame, payload[i]^mask[i%4])
+    }
+    return frame
+}
+
+func TestWebSocketAccept(t *testing.T) {
+    // example from RFC 6455, section 1.3
+    if webSocketAccept("dGhlIHNhbXBsZSBub25jZQ==") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+        t.Fail()
+    }
+}
+
+func TestWebSocketFrames(t *testing.T) {
+    server, client := net.Pipe()
+    wc := &wsConn{server, bufio.NewReader(server), &sync.Mutex{}}
+    go func() {
+        client.Write(maskedFrame(false, wsOpText, "DATA 3 "))
+        client.Write(maskedFrame(true, wsOpContinuation, encodeLine("a b", "c")))
+    }()
+    cmd, params, err := wc.ReceiveErr()
+    if err != nil || cmd != "DATA" || len(params) != 3 || params[0] != "3" || params[1] != "a b" || params[2] != "c" {
+        t.Error(cmd, params, err)
+    }
+
+    go wc.Send("RPLY", "7")
+    reader := bufio.NewReader(client)
+    head := make([]byte, 2)
+    reader.Read(head)
+    payload := make([]byte, head[1])
+    reader.Read(payload)
+    if head[0] != 0x80 | wsOpText || string(payload) != "RPLY 7" {
+        t.Error(head, string(payload))
+    }
+}
+
+func TestWebSocketTooLarge(t *testing.T) {
+    server, client := net.Pipe()
+    wc := &wsConn{server, bufio.NewReader(server), &sync.Mutex{}}
+    go func() {
+        // a header announcing a 2^62 bytes payload
+        client.Write([]byte{0x80 | wsOpText, 127, 0x40, 0, 0, 0, 0, 0, 0, 0})
+        io.Copy(io.Discard, client)
+    }()
+    if _, _, err := wc.ReceiveErr(); err != errWebSocketTooLarge {
+        t.Error(err)
+    }
+}
+
+func TestWebSocketGatewayDisconnect(t *testing.T) {
+    server := testServer(17725)
+    gateway := httptest.NewServer(NewWebSocketGateway(func() Agent {
+        return NewSingleServerAgent(server)
+    }))
+    defer gateway.Close()
+    conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+    if err != nil {
+        t.Fatal(err)
+    }
+    fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: goat\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+        "Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+    reader := bufio.NewReader(conn)
+    resp, err := http.ReadResponse(reader, nil)
+    if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+        t.Fatal(resp, err)
+    }
+    head := make([]byte, 2)
+    io.ReadFull(reader, head)
+    registered := make([]byte, head[1])
+    io.ReadFull(reader, registered)
+    cmd, params := decodeLine(string(registered))
+    if cmd != "Registered" {
+        t.Fatal(cmd, params)
+    }
+    cid := atoi(params[0])
+    connected := func() bool {
+        status, err := QueryCentralServer(server)
+        if err != nil {
+            t.Fatal(err)
+        }
+        for _, comp := range status.Components {
+            if comp.Id == cid {
+                return true
+            }
+        }
+        return false
+    }
+    if !connected() {
+        t.Fatal("the component is not registered")
+    }
+
+    // a DATA for a mid that was never assigned must be ignored, a garbage
+    // tuple must not bring the gateway down
+    conn.Write(maskedFrame(true, wsOpText, encodeLine("DATA", "12345", "0", True().String(), "garbage")))
+    conn.Close()
+    deadline := time.Now().Add(5 * time.Second)
+    for connected() {
+        if time.Now().After(deadline) {
+            t.Fatal("the component is still registered after the socket closed")
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+}